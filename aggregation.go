@@ -0,0 +1,66 @@
+package mockfs
+
+import (
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// An AggregationResult builds the response of the Firestore service to an
+// aggregation query, for use as a scripted RunAggregationQuery message. The
+// results are keyed by the aliases of the aggregations in the request. Finish
+// it with Response.
+type AggregationResult struct {
+	fields   map[string]*pb.Value
+	readTime *timestamppb.Timestamp
+}
+
+// NewAggregationResult starts an aggregation result read at readTime.
+func NewAggregationResult(readTime *timestamppb.Timestamp) *AggregationResult {
+	return &AggregationResult{fields: map[string]*pb.Value{}, readTime: readTime}
+}
+
+// WithCount sets the result of the count aggregation alias.
+func (r *AggregationResult) WithCount(alias string, count int64) *AggregationResult {
+	return r.with(alias, &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: count}})
+}
+
+// WithSum sets the result of the sum aggregation alias over a field holding
+// doubles. Use WithIntegerSum when all the summed values are integers.
+func (r *AggregationResult) WithSum(alias string, sum float64) *AggregationResult {
+	return r.with(alias, &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: sum}})
+}
+
+// WithIntegerSum sets the result of the sum aggregation alias over a field
+// holding only integers.
+func (r *AggregationResult) WithIntegerSum(alias string, sum int64) *AggregationResult {
+	return r.with(alias, &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: sum}})
+}
+
+// WithAvg sets the result of the average aggregation alias.
+func (r *AggregationResult) WithAvg(alias string, avg float64) *AggregationResult {
+	return r.with(alias, &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: avg}})
+}
+
+// WithNullAvg sets the result of the average aggregation alias to null, as
+// the service does when no document has a numeric value for the field.
+func (r *AggregationResult) WithNullAvg(alias string) *AggregationResult {
+	return r.with(alias, &pb.Value{ValueType: &pb.Value_NullValue{NullValue: structpb.NullValue_NULL_VALUE}})
+}
+
+func (r *AggregationResult) with(alias string, v *pb.Value) *AggregationResult {
+	r.fields[alias] = v
+	return r
+}
+
+// Response returns the RunAggregationQuery message holding the result.
+func (r *AggregationResult) Response() *pb.RunAggregationQueryResponse {
+	fields := make(map[string]*pb.Value, len(r.fields))
+	for alias, v := range r.fields {
+		fields[alias] = v
+	}
+	return &pb.RunAggregationQueryResponse{
+		Result:   &pb.AggregationResult{AggregateFields: fields},
+		ReadTime: r.readTime,
+	}
+}
@@ -0,0 +1,78 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func TestAggregationResult(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewAggregationResult(aTimestamp).
+		WithCount("n", 3).
+		WithSum("total", 4.5).
+		WithIntegerSum("units", 7).
+		WithAvg("mean", 1.5).
+		WithNullAvg("none")
+	resp := r.Response()
+	assert.Equal(aTimestamp, resp.ReadTime)
+	fields := resp.Result.AggregateFields
+	assert.Len(fields, 5)
+	assert.Equal(int64(3), fields["n"].GetIntegerValue())
+	assert.Equal(4.5, fields["total"].GetDoubleValue())
+	assert.Equal(int64(7), fields["units"].GetIntegerValue())
+	assert.Equal(1.5, fields["mean"].GetDoubleValue())
+	assert.NotNil(fields["none"].GetValueType().(*pb.Value_NullValue))
+
+	// test later changes to the builder do not alter earlier responses
+	r.WithCount("n", 4)
+	assert.Equal(int64(3), fields["n"].GetIntegerValue())
+	assert.Equal(int64(4), r.Response().Result.AggregateFields["n"].GetIntegerValue())
+}
+
+func TestExpectRunAggregationQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	srv.ExpectRunAggregationQuery(nil).Streams(
+		NewAggregationResult(aTimestamp).
+			WithCount("n", 2).
+			WithIntegerSum("total", 5).
+			WithAvg("mean", 2.5).
+			Response(),
+	)
+	res, err := client.Collection("C").NewAggregationQuery().
+		WithCount("n").
+		WithSum("x", "total").
+		WithAvg("x", "mean").
+		Get(ctx)
+	assert.Nil(err)
+	if assert.Len(res, 3) {
+		assert.Equal(int64(2), res["n"].(*pb.Value).GetIntegerValue())
+		assert.Equal(int64(5), res["total"].(*pb.Value).GetIntegerValue())
+		assert.Equal(2.5, res["mean"].(*pb.Value).GetDoubleValue())
+	}
+	calls := srv.CallsFor(MethodRunAggregationQuery)
+	if assert.Len(calls, 1) {
+		req := calls[0].Request.(*pb.RunAggregationQueryRequest)
+		aggs := req.GetStructuredAggregationQuery().Aggregations
+		if assert.Len(aggs, 3) {
+			assert.Equal("n", aggs[0].Alias)
+			assert.NotNil(aggs[0].GetCount())
+		}
+	}
+
+	// test errors reach the client
+	srv.ExpectRunAggregationQuery(nil).Fails(status.Error(codes.FailedPrecondition, "no index"))
+	_, err = client.Collection("C").NewAggregationQuery().WithCount("n").Get(ctx)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	assert.True(srv.Verify(t))
+}
@@ -0,0 +1,84 @@
+package mockfs
+
+import (
+	"sync"
+
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	status "google.golang.org/grpc/status"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// BatchWriteResponse returns the response of the Firestore service to a
+// BatchWrite request, with one status per write in the order of errs. A nil
+// error marks a write that succeeded at updateTime; the others failed with
+// the status of their error.
+func BatchWriteResponse(updateTime *timestamppb.Timestamp, errs ...error) *pb.BatchWriteResponse {
+	resp := &pb.BatchWriteResponse{}
+	for _, err := range errs {
+		result, st := writeStatus(updateTime, err)
+		resp.WriteResults = append(resp.WriteResults, result)
+		resp.Status = append(resp.Status, st)
+	}
+	return resp
+}
+
+// writeStatus returns the result and status of a single write of a batch.
+func writeStatus(updateTime *timestamppb.Timestamp, err error) (*pb.WriteResult, *spb.Status) {
+	if err == nil {
+		return &pb.WriteResult{UpdateTime: updateTime}, &spb.Status{}
+	}
+	return &pb.WriteResult{}, status.Convert(err).Proto()
+}
+
+// A BatchWriteResult answers BatchWrite requests write by write, keyed by the
+// name of the document each write changes, so the response does not depend
+// on how the client batched the writes. Writes succeed unless a failure was
+// added for their document. Pass its Respond method to RespondsWith.
+type BatchWriteResult struct {
+	mu         sync.Mutex
+	updateTime *timestamppb.Timestamp
+	failures   map[string]*writeFailure
+}
+
+type writeFailure struct {
+	err   error
+	times int // writes left to fail, or negative for no limit
+}
+
+// NewBatchWriteResult starts a batch write result in which writes succeed at
+// updateTime.
+func NewBatchWriteResult(updateTime *timestamppb.Timestamp) *BatchWriteResult {
+	return &BatchWriteResult{updateTime: updateTime, failures: map[string]*writeFailure{}}
+}
+
+// WithFailure makes the next n writes of the document name fail with the
+// status of err, after which they succeed. A negative n fails every write of
+// the document. A client that retries failed writes, such as the BulkWriter,
+// sends them again in a later batch.
+func (r *BatchWriteResult) WithFailure(name string, n int, err error) *BatchWriteResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[name] = &writeFailure{err: err, times: n}
+	return r
+}
+
+// Respond answers req with a status per write.
+func (r *BatchWriteResult) Respond(req *pb.BatchWriteRequest) (*pb.BatchWriteResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resp := &pb.BatchWriteResponse{}
+	for _, w := range req.Writes {
+		var err error
+		if f := r.failures[writeName(w)]; f != nil && f.times != 0 {
+			if f.times > 0 {
+				f.times--
+			}
+			err = f.err
+		}
+		result, st := writeStatus(r.updateTime, err)
+		resp.WriteResults = append(resp.WriteResults, result)
+		resp.Status = append(resp.Status, st)
+	}
+	return resp, nil
+}
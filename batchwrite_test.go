@@ -0,0 +1,96 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	firestore "cloud.google.com/go/firestore"
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func TestBatchWriteResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	resp := BatchWriteResponse(aTimestamp, nil, NotFoundError(testDocs+"/C/b"))
+	if assert.Len(resp.WriteResults, 2) && assert.Len(resp.Status, 2) {
+		assert.Equal(aTimestamp, resp.WriteResults[0].UpdateTime)
+		assert.Equal(int32(codes.OK), resp.Status[0].Code)
+		assert.Nil(resp.WriteResults[1].UpdateTime)
+		assert.Equal(int32(codes.NotFound), resp.Status[1].Code)
+		assert.Len(resp.Status[1].Details, 1)
+	}
+}
+
+func TestBatchWriteResult(t *testing.T) {
+	assert := assert.New(t)
+
+	del := func(name string) *pb.Write {
+		return &pb.Write{Operation: &pb.Write_Delete{Delete: testDocs + "/C/" + name}}
+	}
+	r := NewBatchWriteResult(aTimestamp).
+		WithFailure(testDocs+"/C/a", 1, status.Error(codes.Unavailable, "try again")).
+		WithFailure(testDocs+"/C/b", -1, status.Error(codes.PermissionDenied, "denied"))
+	codesOf := func(req *pb.BatchWriteRequest) []codes.Code {
+		resp, err := r.Respond(req)
+		assert.Nil(err)
+		var got []codes.Code
+		for _, st := range resp.Status {
+			got = append(got, codes.Code(st.Code))
+		}
+		return got
+	}
+
+	assert.Equal([]codes.Code{codes.OK, codes.Unavailable, codes.PermissionDenied},
+		codesOf(&pb.BatchWriteRequest{Writes: []*pb.Write{del("c"), del("a"), del("b")}}))
+	assert.Equal([]codes.Code{codes.PermissionDenied, codes.OK},
+		codesOf(&pb.BatchWriteRequest{Writes: []*pb.Write{del("b"), del("a")}}))
+}
+
+func TestBulkWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	var writes []*pb.Write
+	for _, name := range []string{"a", "b", "c"} {
+		writes = append(writes, &pb.Write{Operation: &pb.Write_Delete{Delete: testDocs + "/C/" + name}})
+	}
+	result := NewBatchWriteResult(aTimestamp).
+		WithFailure(testDocs+"/C/b", 1, status.Error(codes.Unavailable, "try again"))
+	srv.ExpectBatchWrite(nil).Matching(BatchOf(writes...)).RespondsWith(result.Respond).AtLeast(2)
+
+	// test the failed write is sent again in a later batch
+	bw := client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, name := range []string{"a", "b", "c"} {
+		job, err := bw.Delete(client.Collection("C").Doc(name))
+		assert.Nil(err)
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	for _, job := range jobs {
+		res, err := job.Results()
+		if assert.Nil(err) {
+			assert.Equal(aTime, res.UpdateTime)
+		}
+	}
+	calls := srv.CallsFor(MethodBatchWrite)
+	if assert.Len(calls, 2) {
+		assert.Equal([]string{testDocs + "/C/b"}, documentNames(calls[1].Request))
+	}
+	assert.True(srv.Verify(t))
+
+	// test a failed call fails every write of the batch
+	srv.ExpectBatchWrite(nil).Fails(status.Error(codes.PermissionDenied, "denied"))
+	bw = client.BulkWriter(ctx)
+	job, err := bw.Delete(client.Collection("C").Doc("d"))
+	assert.Nil(err)
+	bw.End()
+	_, err = job.Results()
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+}
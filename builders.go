@@ -0,0 +1,224 @@
+package mockfs
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// A UnaryCall builds an expectation for a unary RPC whose request and
+// response types are checked at compile time. It is created by one of the
+// MockServer Expect methods, and the expectation is added once its response
+// is set.
+type UnaryCall[Req, Resp proto.Message] struct {
+	srv     *MockServer
+	method  string
+	matcher Matcher
+	wantReq proto.Message
+}
+
+// Matching replaces the request check with m.
+func (c *UnaryCall[Req, Resp]) Matching(m Matcher) *UnaryCall[Req, Resp] {
+	c.matcher, c.wantReq = m, nil
+	return c
+}
+
+// Returns adds the expectation, answering with resp.
+func (c *UnaryCall[Req, Resp]) Returns(resp Resp) *Expectation {
+	return c.srv.add(newExpectation(c.method, c.matcher, c.wantReq, resp))
+}
+
+// Fails adds the expectation, answering with err.
+func (c *UnaryCall[Req, Resp]) Fails(err error) *Expectation {
+	return c.srv.add(newExpectation(c.method, c.matcher, c.wantReq, err))
+}
+
+// RespondsWith adds the expectation, answering with the result of calling fn
+// with the request each time the expectation is matched.
+func (c *UnaryCall[Req, Resp]) RespondsWith(fn func(req Req) (Resp, error)) *Expectation {
+	return c.srv.add(newExpectation(c.method, c.matcher, c.wantReq, fn))
+}
+
+// RespondsWithContext is like RespondsWith, but fn also receives the call's
+// context, for example to set response metadata with grpc.SetHeader.
+func (c *UnaryCall[Req, Resp]) RespondsWithContext(fn func(ctx context.Context, req Req) (Resp, error)) *Expectation {
+	return c.srv.add(newExpectation(c.method, c.matcher, c.wantReq, fn))
+}
+
+// A StreamCall builds an expectation for a server-streaming RPC whose request
+// and response types are checked at compile time. It is created by one of
+// the MockServer Expect methods, and the expectation is added once its
+// responses are set.
+type StreamCall[Req, Resp proto.Message] struct {
+	srv     *MockServer
+	method  string
+	matcher Matcher
+	wantReq proto.Message
+}
+
+// Matching replaces the request check with m.
+func (c *StreamCall[Req, Resp]) Matching(m Matcher) *StreamCall[Req, Resp] {
+	c.matcher, c.wantReq = m, nil
+	return c
+}
+
+// Streams adds the expectation, sending resps in order and then ending the
+// stream successfully.
+func (c *StreamCall[Req, Resp]) Streams(resps ...Resp) *Expectation {
+	return c.srv.add(newExpectation(c.method, c.matcher, c.wantReq, streamOf(resps, nil)))
+}
+
+// StreamsThenFails adds the expectation, sending resps in order and then
+// ending the stream with err.
+func (c *StreamCall[Req, Resp]) StreamsThenFails(err error, resps ...Resp) *Expectation {
+	return c.srv.add(newExpectation(c.method, c.matcher, c.wantReq, streamOf(resps, err)))
+}
+
+// Fails adds the expectation, failing the call with err before any message is
+// sent.
+func (c *StreamCall[Req, Resp]) Fails(err error) *Expectation {
+	return c.srv.add(newExpectation(c.method, c.matcher, c.wantReq, err))
+}
+
+// RespondsWith adds the expectation, sending the messages returned by calling
+// fn with the request each time the expectation is matched.
+func (c *StreamCall[Req, Resp]) RespondsWith(fn func(req Req) ([]Resp, error)) *Expectation {
+	return c.srv.add(newExpectation(c.method, c.matcher, c.wantReq, fn))
+}
+
+// RespondsWithContext is like RespondsWith, but fn also receives the call's
+// context, for example to set response metadata with grpc.SetHeader.
+func (c *StreamCall[Req, Resp]) RespondsWithContext(fn func(ctx context.Context, req Req) ([]Resp, error)) *Expectation {
+	return c.srv.add(newExpectation(c.method, c.matcher, c.wantReq, fn))
+}
+
+// A ListenCall builds an expectation for the Listen RPC.
+type ListenCall struct {
+	*StreamCall[*pb.ListenRequest, *pb.ListenResponse]
+}
+
+// Sends adds the expectation, sending resps in order and then ending the
+// stream successfully.
+func (c ListenCall) Sends(resps ...*pb.ListenResponse) *Expectation {
+	return c.Streams(resps...)
+}
+
+// ExpectGetDocument starts an expectation for a GetDocument request equal to
+// req. A nil req accepts any GetDocument request.
+func (s *MockServer) ExpectGetDocument(req *pb.GetDocumentRequest) *UnaryCall[*pb.GetDocumentRequest, *pb.Document] {
+	return newUnaryCall[*pb.GetDocumentRequest, *pb.Document](s, MethodGetDocument, req)
+}
+
+// ExpectCommit starts an expectation for a Commit request equal to req. A nil
+// req accepts any Commit request.
+func (s *MockServer) ExpectCommit(req *pb.CommitRequest) *UnaryCall[*pb.CommitRequest, *pb.CommitResponse] {
+	return newUnaryCall[*pb.CommitRequest, *pb.CommitResponse](s, MethodCommit, req)
+}
+
+// ExpectBeginTransaction starts an expectation for a BeginTransaction request
+// equal to req. A nil req accepts any BeginTransaction request.
+func (s *MockServer) ExpectBeginTransaction(req *pb.BeginTransactionRequest) *UnaryCall[*pb.BeginTransactionRequest, *pb.BeginTransactionResponse] {
+	return newUnaryCall[*pb.BeginTransactionRequest, *pb.BeginTransactionResponse](s, MethodBeginTransaction, req)
+}
+
+// ExpectRollback starts an expectation for a Rollback request equal to req. A
+// nil req accepts any Rollback request.
+func (s *MockServer) ExpectRollback(req *pb.RollbackRequest) *UnaryCall[*pb.RollbackRequest, *empty.Empty] {
+	return newUnaryCall[*pb.RollbackRequest, *empty.Empty](s, MethodRollback, req)
+}
+
+// ExpectListDocuments starts an expectation for a ListDocuments request equal
+// to req. A nil req accepts any ListDocuments request. Use ReturnsPages to
+// script a listing of several pages.
+func (s *MockServer) ExpectListDocuments(req *pb.ListDocumentsRequest) PagedCall[*pb.ListDocumentsRequest, *pb.ListDocumentsResponse] {
+	return PagedCall[*pb.ListDocumentsRequest, *pb.ListDocumentsResponse]{
+		newUnaryCall[*pb.ListDocumentsRequest, *pb.ListDocumentsResponse](s, MethodListDocuments, req),
+	}
+}
+
+// ExpectPartitionQuery starts an expectation for a PartitionQuery request
+// equal to req. A nil req accepts any PartitionQuery request. Build the
+// partitions with PartitionCursors, and use ReturnsPages to script several
+// pages of them.
+func (s *MockServer) ExpectPartitionQuery(req *pb.PartitionQueryRequest) PagedCall[*pb.PartitionQueryRequest, *pb.PartitionQueryResponse] {
+	return PagedCall[*pb.PartitionQueryRequest, *pb.PartitionQueryResponse]{
+		newUnaryCall[*pb.PartitionQueryRequest, *pb.PartitionQueryResponse](s, MethodPartitionQuery, req),
+	}
+}
+
+// ExpectListCollectionIds starts an expectation for a ListCollectionIds
+// request equal to req. A nil req accepts any ListCollectionIds request. Use
+// ReturnsPages to script a listing of several pages.
+func (s *MockServer) ExpectListCollectionIds(req *pb.ListCollectionIdsRequest) PagedCall[*pb.ListCollectionIdsRequest, *pb.ListCollectionIdsResponse] {
+	return PagedCall[*pb.ListCollectionIdsRequest, *pb.ListCollectionIdsResponse]{
+		newUnaryCall[*pb.ListCollectionIdsRequest, *pb.ListCollectionIdsResponse](s, MethodListCollectionIds, req),
+	}
+}
+
+// ExpectBatchWrite starts an expectation for a BatchWrite request equal to
+// req. A nil req accepts any BatchWrite request. As the client batches writes
+// as they are queued, tests of a BulkWriter usually match its requests with
+// BatchOf and answer them with a BatchWriteResult.
+func (s *MockServer) ExpectBatchWrite(req *pb.BatchWriteRequest) *UnaryCall[*pb.BatchWriteRequest, *pb.BatchWriteResponse] {
+	return newUnaryCall[*pb.BatchWriteRequest, *pb.BatchWriteResponse](s, MethodBatchWrite, req)
+}
+
+// ExpectBatchGetDocuments starts an expectation for a BatchGetDocuments
+// request equal to req. A nil req accepts any BatchGetDocuments request.
+func (s *MockServer) ExpectBatchGetDocuments(req *pb.BatchGetDocumentsRequest) *StreamCall[*pb.BatchGetDocumentsRequest, *pb.BatchGetDocumentsResponse] {
+	return newStreamCall[*pb.BatchGetDocumentsRequest, *pb.BatchGetDocumentsResponse](s, MethodBatchGetDocuments, req)
+}
+
+// ExpectRunQuery starts an expectation for a RunQuery request equal to req. A
+// nil req accepts any RunQuery request.
+func (s *MockServer) ExpectRunQuery(req *pb.RunQueryRequest) *StreamCall[*pb.RunQueryRequest, *pb.RunQueryResponse] {
+	return newStreamCall[*pb.RunQueryRequest, *pb.RunQueryResponse](s, MethodRunQuery, req)
+}
+
+// ExpectRunAggregationQuery starts an expectation for a RunAggregationQuery
+// request equal to req. A nil req accepts any RunAggregationQuery request.
+// Build the responses with NewAggregationResult.
+func (s *MockServer) ExpectRunAggregationQuery(req *pb.RunAggregationQueryRequest) *StreamCall[*pb.RunAggregationQueryRequest, *pb.RunAggregationQueryResponse] {
+	return newStreamCall[*pb.RunAggregationQueryRequest, *pb.RunAggregationQueryResponse](s, MethodRunAggregationQuery, req)
+}
+
+// ExpectListen starts an expectation for a Listen stream whose first request
+// is equal to req. A nil req accepts any Listen request.
+func (s *MockServer) ExpectListen(req *pb.ListenRequest) ListenCall {
+	return ListenCall{newStreamCall[*pb.ListenRequest, *pb.ListenResponse](s, MethodListen, req)}
+}
+
+func newUnaryCall[Req, Resp proto.Message](s *MockServer, method string, req Req) *UnaryCall[Req, Resp] {
+	m, wantReq := requestMatcher(req)
+	return &UnaryCall[Req, Resp]{srv: s, method: method, matcher: m, wantReq: wantReq}
+}
+
+func newStreamCall[Req, Resp proto.Message](s *MockServer, method string, req Req) *StreamCall[Req, Resp] {
+	m, wantReq := requestMatcher(req)
+	return &StreamCall[Req, Resp]{srv: s, method: method, matcher: m, wantReq: wantReq}
+}
+
+// requestMatcher returns the matcher for an expected request, which may be a
+// nil pointer to accept any request.
+func requestMatcher(req proto.Message) (Matcher, proto.Message) {
+	if req == nil || reflect.ValueOf(req).IsNil() {
+		return Any(), nil
+	}
+	return Equal(req), req
+}
+
+// streamOf converts typed stream messages, optionally followed by an error,
+// into the []interface{} form used by the streaming handlers.
+func streamOf[Resp proto.Message](resps []Resp, err error) []interface{} {
+	values := make([]interface{}, 0, len(resps)+1)
+	for _, resp := range resps {
+		values = append(values, resp)
+	}
+	if err != nil {
+		values = append(values, err)
+	}
+	return values
+}
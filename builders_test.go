@@ -0,0 +1,130 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	errors "github.com/weathersource/go-errors"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	testDB   = "projects/projectID/databases/(default)"
+	testDocs = testDB + "/documents"
+)
+
+func TestExpectUnary(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	srv.ExpectGetDocument(&pb.GetDocumentRequest{Name: "a"}).Returns(&pb.Document{Name: "a"})
+	doc, err := srv.GetDocument(ctx, &pb.GetDocumentRequest{Name: "a"})
+	assert.Nil(err)
+	assert.Equal("a", doc.Name)
+
+	srv.ExpectCommit(nil).Fails(errors.NewAbortedError(""))
+	_, err = srv.Commit(ctx, &pb.CommitRequest{Database: "d"})
+	assert.Equal(codes.Aborted, status.Code(err))
+
+	srv.ExpectBeginTransaction(nil).RespondsWith(func(req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+		return &pb.BeginTransactionResponse{Transaction: []byte(req.Database)}, nil
+	})
+	txn, err := srv.BeginTransaction(ctx, &pb.BeginTransactionRequest{Database: "d"})
+	assert.Nil(err)
+	assert.Equal([]byte("d"), txn.Transaction)
+
+	srv.ExpectRollback(nil).Matching(DocumentNameMatches("x")).Returns(&empty.Empty{})
+	_, err = srv.Rollback(ctx, &pb.RollbackRequest{})
+	assert.NotNil(err)
+
+	// test typed expectations with a nil request are still scoped to their method
+	srv.ExpectCommit(nil).Returns(&pb.CommitResponse{})
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	_, err = srv.Commit(ctx, &pb.CommitRequest{})
+	assert.Nil(err)
+}
+
+func TestExpectStream(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	path := testDocs + "/C/a"
+	srv.ExpectBatchGetDocuments(&pb.BatchGetDocumentsRequest{
+		Database:  testDB,
+		Documents: []string{path},
+	}).Streams(&pb.BatchGetDocumentsResponse{
+		Result:   &pb.BatchGetDocumentsResponse_Missing{Missing: path},
+		ReadTime: aTimestamp,
+	})
+	_, err = client.Collection("C").Doc("a").Get(ctx)
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	srv.ExpectRunQuery(nil).Streams(
+		&pb.RunQueryResponse{
+			Document: &pb.Document{Name: testDocs + "/C/b", CreateTime: aTimestamp, UpdateTime: aTimestamp},
+			ReadTime: aTimestamp,
+		},
+	)
+	docs, err := client.Collection("C").Documents(ctx).GetAll()
+	assert.Nil(err)
+	assert.Len(docs, 1)
+
+	srv.ExpectRunQuery(nil).StreamsThenFails(errors.NewPermissionDeniedError(""), &pb.RunQueryResponse{ReadTime: aTimestamp})
+	_, err = client.Collection("C").Documents(ctx).GetAll()
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+
+	srv.ExpectRunQuery(nil).Fails(errors.NewPermissionDeniedError(""))
+	_, err = client.Collection("C").Documents(ctx).GetAll()
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+
+	srv.ExpectRunQuery(nil).RespondsWith(func(req *pb.RunQueryRequest) ([]*pb.RunQueryResponse, error) {
+		return []*pb.RunQueryResponse{{
+			Document: &pb.Document{Name: req.Parent + "/C/c", CreateTime: aTimestamp, UpdateTime: aTimestamp},
+			ReadTime: aTimestamp,
+		}}, nil
+	})
+	docs, err = client.Collection("C").Documents(ctx).GetAll()
+	assert.Nil(err)
+	if assert.Len(docs, 1) {
+		assert.Equal("c", docs[0].Ref.ID)
+	}
+	assert.True(srv.Verify(t))
+}
+
+func TestExpectListen(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+
+	srv.ExpectListen(nil).Sends(
+		&pb.ListenResponse{ResponseType: &pb.ListenResponse_TargetChange{TargetChange: &pb.TargetChange{
+			TargetChangeType: pb.TargetChange_ADD,
+			TargetIds:        []int32{'g' + 'o'},
+		}}},
+		&pb.ListenResponse{ResponseType: &pb.ListenResponse_TargetChange{TargetChange: &pb.TargetChange{
+			TargetChangeType: pb.TargetChange_CURRENT,
+		}}},
+		&pb.ListenResponse{ResponseType: &pb.ListenResponse_TargetChange{TargetChange: &pb.TargetChange{
+			ReadTime: aTimestamp,
+		}}},
+	)
+	it := client.Collection("C").Snapshots(context.Background())
+	defer it.Stop()
+	snap, err := it.Next()
+	assert.Nil(err)
+	if assert.NotNil(snap) {
+		assert.Equal(0, snap.Size)
+	}
+}
@@ -0,0 +1,218 @@
+package mockfs
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// A CanonRule rewrites a request in place into a canonical form, for example
+// by sorting a repeated field whose order does not matter. The server applies
+// its enabled rules to both the incoming request and the expected request
+// before comparing them.
+type CanonRule func(req proto.Message)
+
+// Names of the built-in canonicalization rules. All of them are enabled by
+// default.
+const (
+	// CanonFieldTransforms sorts document field transforms by field path.
+	CanonFieldTransforms = "field-transforms"
+	// CanonDocuments sorts the document names of a BatchGetDocumentsRequest.
+	CanonDocuments = "documents"
+	// CanonDocumentMask sorts the field paths of read masks.
+	CanonDocumentMask = "document-mask"
+	// CanonUpdateMask sorts the field paths of write update masks.
+	CanonUpdateMask = "update-mask"
+	// CanonWrites sorts the writes of a commit or batch write by document
+	// name, provided no two writes change the same document.
+	CanonWrites = "writes"
+	// CanonInValues sorts the array values of IN, NOT_IN and
+	// ARRAY_CONTAINS_ANY query filters.
+	CanonInValues = "in-values"
+)
+
+type canonRule struct {
+	name    string
+	rule    CanonRule
+	enabled bool
+}
+
+func defaultCanonRules() []*canonRule {
+	return []*canonRule{
+		{CanonFieldTransforms, canonFieldTransforms, true},
+		{CanonDocuments, canonDocuments, true},
+		{CanonDocumentMask, canonDocumentMask, true},
+		{CanonUpdateMask, canonUpdateMask, true},
+		{CanonWrites, canonWrites, true},
+		{CanonInValues, canonInValues, true},
+	}
+}
+
+// AddCanonRule adds an enabled canonicalization rule under name, replacing
+// any rule already registered with that name. Rules run in the order they
+// were added, after the built-in rules.
+func (s *MockServer) AddCanonRule(name string, rule CanonRule) {
+	s.addCanonRule(name, rule, false)
+}
+
+// addCanonRule adds or replaces the named rule. New rules run after the
+// existing ones, or before them if first is true.
+func (s *MockServer) addCanonRule(name string, rule CanonRule, first bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.canonRules {
+		if r.name == name {
+			r.rule, r.enabled = rule, true
+			return
+		}
+	}
+	r := &canonRule{name, rule, true}
+	if first {
+		s.canonRules = append([]*canonRule{r}, s.canonRules...)
+	} else {
+		s.canonRules = append(s.canonRules, r)
+	}
+}
+
+// SetCanonRuleEnabled switches the named canonicalization rule on or off. It
+// panics if no rule is registered under name.
+func (s *MockServer) SetCanonRuleEnabled(name string, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.canonRules {
+		if r.name == name {
+			r.enabled = enabled
+			return
+		}
+	}
+	panic(fmt.Sprintf("mockfs.SetCanonRuleEnabled: unknown rule %q", name))
+}
+
+// canonicalize applies the enabled rules to req. The caller must hold s.mu.
+func (s *MockServer) canonicalize(req proto.Message) {
+	if req == nil {
+		return
+	}
+	for _, r := range s.canonRules {
+		if r.enabled {
+			r.rule(req)
+		}
+	}
+}
+
+// walkMessages calls fn for m and every message nested within it.
+func walkMessages(m protoreflect.Message, fn func(proto.Message)) {
+	fn(proto.MessageV1(m.Interface()))
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				walkMessages(l.Get(i).Message(), fn)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				walkMessages(mv.Message(), fn)
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			walkMessages(v.Message(), fn)
+		}
+		return true
+	})
+}
+
+func walk(req proto.Message, fn func(proto.Message)) {
+	walkMessages(proto.MessageReflect(req), fn)
+}
+
+func canonFieldTransforms(req proto.Message) {
+	walk(req, func(m proto.Message) {
+		switch m := m.(type) {
+		case *pb.DocumentTransform:
+			sort.Sort(byFieldPath(m.FieldTransforms))
+		case *pb.Write:
+			sort.Sort(byFieldPath(m.UpdateTransforms))
+		}
+	})
+}
+
+func canonDocuments(req proto.Message) {
+	if req, ok := req.(*pb.BatchGetDocumentsRequest); ok {
+		sort.Strings(req.Documents)
+	}
+}
+
+func canonDocumentMask(req proto.Message) {
+	walk(req, func(m proto.Message) {
+		switch m := m.(type) {
+		case *pb.GetDocumentRequest:
+			sortMask(m.Mask)
+		case *pb.BatchGetDocumentsRequest:
+			sortMask(m.Mask)
+		}
+	})
+}
+
+func canonUpdateMask(req proto.Message) {
+	walk(req, func(m proto.Message) {
+		if w, ok := m.(*pb.Write); ok {
+			sortMask(w.UpdateMask)
+		}
+	})
+}
+
+func sortMask(mask *pb.DocumentMask) {
+	if mask != nil {
+		sort.Strings(mask.FieldPaths)
+	}
+}
+
+func canonWrites(req proto.Message) {
+	switch req := req.(type) {
+	case *pb.CommitRequest:
+		sortWrites(req.Writes)
+	case *pb.BatchWriteRequest:
+		sortWrites(req.Writes)
+	}
+}
+
+// sortWrites sorts writes by document name, unless two of them change the
+// same document, in which case their order matters.
+func sortWrites(writes []*pb.Write) {
+	seen := map[string]bool{}
+	for _, w := range writes {
+		name := writeName(w)
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+	}
+	sort.SliceStable(writes, func(i, j int) bool { return writeName(writes[i]) < writeName(writes[j]) })
+}
+
+func canonInValues(req proto.Message) {
+	walk(req, func(m proto.Message) {
+		f, ok := m.(*pb.StructuredQuery_FieldFilter)
+		if !ok {
+			return
+		}
+		switch f.Op {
+		case pb.StructuredQuery_FieldFilter_IN,
+			pb.StructuredQuery_FieldFilter_NOT_IN,
+			pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY:
+			if values := f.GetValue().GetArrayValue().GetValues(); values != nil {
+				sort.SliceStable(values, func(i, j int) bool { return formatValue(values[i]) < formatValue(values[j]) })
+			}
+		}
+	})
+}
+
+type byFieldPath []*pb.DocumentTransform_FieldTransform
+
+func (a byFieldPath) Len() int           { return len(a) }
+func (a byFieldPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byFieldPath) Less(i, j int) bool { return a[i].FieldPath < a[j].FieldPath }
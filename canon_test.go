@@ -0,0 +1,154 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func canonAll(req proto.Message) {
+	for _, r := range defaultCanonRules() {
+		r.rule(req)
+	}
+}
+
+func TestCanonRules(t *testing.T) {
+	assert := assert.New(t)
+
+	inFilter := func(op pb.StructuredQuery_FieldFilter_Operator, values ...*pb.Value) *pb.RunQueryRequest {
+		return &pb.RunQueryRequest{
+			QueryType: &pb.RunQueryRequest_StructuredQuery{StructuredQuery: &pb.StructuredQuery{
+				Where: &pb.StructuredQuery_Filter{FilterType: &pb.StructuredQuery_Filter_FieldFilter{
+					FieldFilter: &pb.StructuredQuery_FieldFilter{
+						Field: &pb.StructuredQuery_FieldReference{FieldPath: "a"},
+						Op:    op,
+						Value: &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: values}}},
+					},
+				}},
+			}},
+		}
+	}
+	update := func(name string, mask ...string) *pb.Write {
+		return &pb.Write{
+			Operation:  &pb.Write_Update{Update: &pb.Document{Name: name}},
+			UpdateMask: &pb.DocumentMask{FieldPaths: mask},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		got, want proto.Message
+	}{
+		{
+			"batch get documents and mask",
+			&pb.BatchGetDocumentsRequest{Documents: []string{"b", "a"}, Mask: &pb.DocumentMask{FieldPaths: []string{"y", "x"}}},
+			&pb.BatchGetDocumentsRequest{Documents: []string{"a", "b"}, Mask: &pb.DocumentMask{FieldPaths: []string{"x", "y"}}},
+		},
+		{
+			"get document mask",
+			&pb.GetDocumentRequest{Mask: &pb.DocumentMask{FieldPaths: []string{"y", "x"}}},
+			&pb.GetDocumentRequest{Mask: &pb.DocumentMask{FieldPaths: []string{"x", "y"}}},
+		},
+		{
+			"writes and update masks",
+			&pb.CommitRequest{Writes: []*pb.Write{update("b", "q", "p"), update("a")}},
+			&pb.CommitRequest{Writes: []*pb.Write{update("a"), update("b", "p", "q")}},
+		},
+		{
+			"writes to the same document keep their order",
+			&pb.CommitRequest{Writes: []*pb.Write{update("b"), update("a"), update("b", "x")}},
+			&pb.CommitRequest{Writes: []*pb.Write{update("b"), update("a"), update("b", "x")}},
+		},
+		{
+			"batch writes",
+			&pb.BatchWriteRequest{Writes: []*pb.Write{update("b"), update("a")}},
+			&pb.BatchWriteRequest{Writes: []*pb.Write{update("a"), update("b")}},
+		},
+		{
+			"update transforms",
+			&pb.CommitRequest{Writes: []*pb.Write{{UpdateTransforms: []*pb.DocumentTransform_FieldTransform{{FieldPath: "b"}, {FieldPath: "a"}}}}},
+			&pb.CommitRequest{Writes: []*pb.Write{{UpdateTransforms: []*pb.DocumentTransform_FieldTransform{{FieldPath: "a"}, {FieldPath: "b"}}}}},
+		},
+		{
+			"in values",
+			inFilter(pb.StructuredQuery_FieldFilter_IN, intValue(2), intValue(1)),
+			inFilter(pb.StructuredQuery_FieldFilter_IN, intValue(1), intValue(2)),
+		},
+		{
+			"array contains any values",
+			inFilter(pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY, stringValue("y"), stringValue("x")),
+			inFilter(pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY, stringValue("x"), stringValue("y")),
+		},
+		{
+			"equality arrays keep their order",
+			inFilter(pb.StructuredQuery_FieldFilter_EQUAL, intValue(2), intValue(1)),
+			inFilter(pb.StructuredQuery_FieldFilter_EQUAL, intValue(2), intValue(1)),
+		},
+	}
+	for _, test := range tests {
+		canonAll(test.got)
+		assert.True(proto.Equal(test.want, test.got), test.name)
+	}
+}
+
+func TestCanonMatching(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	// test expected and received requests are both canonicalized
+	srv.ExpectBatchGetDocuments(&pb.BatchGetDocumentsRequest{Documents: []string{"b", "a"}}).Streams()
+	err = srv.BatchGetDocuments(&pb.BatchGetDocumentsRequest{Documents: []string{"a", "b"}}, &BatchGetDocumentsServer{})
+	assert.Nil(err)
+
+	srv.AddRPCMatcher(MethodBatchGetDocuments, Not(Partial(&pb.BatchGetDocumentsRequest{Documents: []string{"b", "a"}})), []interface{}{})
+	err = srv.BatchGetDocuments(&pb.BatchGetDocumentsRequest{Documents: []string{"a", "b"}}, &BatchGetDocumentsServer{})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	srv.Reset()
+
+	// test rules can be switched off
+	srv.SetCanonRuleEnabled(CanonDocuments, false)
+	srv.ExpectBatchGetDocuments(&pb.BatchGetDocumentsRequest{Documents: []string{"b", "a"}}).Streams()
+	err = srv.BatchGetDocuments(&pb.BatchGetDocumentsRequest{Documents: []string{"a", "b"}}, &BatchGetDocumentsServer{})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	srv.Reset()
+	srv.SetCanonRuleEnabled(CanonDocuments, true)
+	assert.Panics(func() { srv.SetCanonRuleEnabled("no-such-rule", false) })
+
+	// test user rules
+	srv.AddCanonRule("database", func(req proto.Message) {
+		if req, ok := req.(*pb.GetDocumentRequest); ok {
+			req.Name = shortName(req.Name)
+		}
+	})
+	srv.ExpectGetDocument(&pb.GetDocumentRequest{Name: "C/a"}).Returns(&pb.Document{})
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{Name: testDocs + "/C/a"})
+	assert.Nil(err)
+	srv.SetCanonRuleEnabled("database", false)
+	srv.ExpectGetDocument(&pb.GetDocumentRequest{Name: "C/a"}).Returns(&pb.Document{})
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{Name: testDocs + "/C/a"})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+
+	// test responders see the request as received
+	srv.Reset()
+	gotReq := &pb.CommitRequest{Writes: []*pb.Write{
+		{Operation: &pb.Write_Delete{Delete: "b"}},
+		{Operation: &pb.Write_Delete{Delete: "a"}},
+	}}
+	srv.ExpectCommit(&pb.CommitRequest{Writes: []*pb.Write{
+		{Operation: &pb.Write_Delete{Delete: "a"}},
+		{Operation: &pb.Write_Delete{Delete: "b"}},
+	}}).RespondsWith(func(req *pb.CommitRequest) (*pb.CommitResponse, error) {
+		assert.Equal("b", req.Writes[0].GetDelete())
+		return &pb.CommitResponse{}, nil
+	})
+	_, err = srv.Commit(ctx, gotReq)
+	assert.Nil(err)
+}
@@ -0,0 +1,204 @@
+package mockfs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Var returns a placeholder for the value captured under name, for use in
+// the string and bytes fields of expected requests and responses, either on
+// its own or within a longer value. Use []byte(Var(name)) for bytes fields.
+// Placeholders are resolved each time a request arrives, with the values
+// captured so far; see Expectation.Capture.
+func Var(name string) string {
+	return "${" + name + "}"
+}
+
+var varPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+type capture struct {
+	name string
+	path string
+}
+
+// Capture saves the value of a field of the request matching the
+// expectation under name, so that later expected requests and responses can
+// refer to it with Var(name). The field is given by a dot-separated path of
+// proto field names, list indexes and map keys, such as
+// "writes.0.update.name". It must be a string, bytes, numeric or bool field.
+func (e *Expectation) Capture(name, path string) *Expectation {
+	e.srv.mu.Lock()
+	defer e.srv.mu.Unlock()
+	e.captures = append(e.captures, capture{name, path})
+	return e
+}
+
+// Captured returns the value captured under name, if any.
+func (s *MockServer) Captured(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.vars[name]
+	return v, ok
+}
+
+// capture saves the values captured by e from gotReq. It returns a message
+// describing the failure if a field cannot be captured. The caller must hold
+// s.mu.
+func (s *MockServer) capture(e *Expectation, gotReq proto.Message) string {
+	for _, c := range e.captures {
+		v, err := fieldValue(gotReq, c.path)
+		if err != nil {
+			return fmt.Sprintf("mockfs.popRPC: Cannot capture %q from %T: %v", c.name, gotReq, err)
+		}
+		if s.vars == nil {
+			s.vars = map[string]string{}
+		}
+		s.vars[c.name] = v
+	}
+	return ""
+}
+
+// fieldValue returns the value of the field of m at path, formatted as a
+// string.
+func fieldValue(m proto.Message, path string) (string, error) {
+	if m == nil {
+		return "", fmt.Errorf("no request")
+	}
+	mr := proto.MessageReflect(m)
+	var fd protoreflect.FieldDescriptor
+	var v protoreflect.Value
+	segs := strings.Split(path, ".")
+	for i := 0; i < len(segs); i++ {
+		if fd != nil {
+			if fd.Message() == nil {
+				return "", fmt.Errorf("%s is not a message", strings.Join(segs[:i], "."))
+			}
+			mr = v.Message()
+		}
+		fd = mr.Descriptor().Fields().ByName(protoreflect.Name(segs[i]))
+		if fd == nil {
+			return "", fmt.Errorf("no field %s in %s", strings.Join(segs[:i+1], "."), mr.Descriptor().FullName())
+		}
+		if !mr.Has(fd) {
+			return "", fmt.Errorf("%s is not set", strings.Join(segs[:i+1], "."))
+		}
+		v = mr.Get(fd)
+		switch {
+		case fd.IsList():
+			if i++; i == len(segs) {
+				return "", fmt.Errorf("%s is a list", path)
+			}
+			n, err := strconv.Atoi(segs[i])
+			if err != nil || n < 0 || n >= v.List().Len() {
+				return "", fmt.Errorf("no element %s", strings.Join(segs[:i+1], "."))
+			}
+			v = v.List().Get(n)
+		case fd.IsMap():
+			if i++; i == len(segs) || fd.MapKey().Kind() != protoreflect.StringKind {
+				return "", fmt.Errorf("%s is a map", path)
+			}
+			k := protoreflect.ValueOfString(segs[i]).MapKey()
+			if !v.Map().Has(k) {
+				return "", fmt.Errorf("no entry %s", strings.Join(segs[:i+1], "."))
+			}
+			v = v.Map().Get(k)
+			fd = fd.MapValue()
+		}
+	}
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return "", fmt.Errorf("%s is a message", path)
+	case protoreflect.BytesKind:
+		return string(v.Bytes()), nil
+	case protoreflect.EnumKind:
+		return strconv.Itoa(int(v.Enum())), nil
+	default:
+		return fmt.Sprint(v.Interface()), nil
+	}
+}
+
+// bind replaces the placeholders in m with the values captured so far.
+// Placeholders of names not captured yet are left unchanged. The caller must
+// hold s.mu.
+func (s *MockServer) bind(m proto.Message) {
+	if m == nil || len(s.vars) == 0 {
+		return
+	}
+	replace := func(match string) string {
+		if v, ok := s.vars[match[2:len(match)-1]]; ok {
+			return v
+		}
+		return match
+	}
+	rewriteFields(m, func(fd protoreflect.FieldDescriptor, v protoreflect.Value) protoreflect.Value {
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			return protoreflect.ValueOfString(varPattern.ReplaceAllStringFunc(v.String(), replace))
+		case protoreflect.BytesKind:
+			return protoreflect.ValueOfBytes(varPattern.ReplaceAllFunc(v.Bytes(), func(b []byte) []byte {
+				return []byte(replace(string(b)))
+			}))
+		}
+		return v
+	})
+}
+
+// bindResponse returns a scripted response with the placeholders in its
+// messages replaced by the values captured so far. Messages are copied
+// before being changed.
+func (s *MockServer) bindResponse(resp interface{}) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.vars) == 0 {
+		return resp
+	}
+	bindMessage := func(v interface{}) interface{} {
+		if m, ok := v.(proto.Message); ok {
+			m = proto.Clone(m)
+			s.bind(m)
+			return m
+		}
+		return v
+	}
+	values, ok := resp.([]interface{})
+	if !ok {
+		return bindMessage(resp)
+	}
+	bound := make([]interface{}, len(values))
+	for i, v := range values {
+		bound[i] = bindMessage(v)
+	}
+	return bound
+}
+
+// normalizers returns the functions that prepare messages for comparison:
+// canonReq canonicalizes incoming requests, and canonWant also replaces the
+// placeholders in expected requests before canonicalizing them. Placeholders
+// are never bound in incoming requests, so a client cannot match a captured
+// value by sending its placeholder. Both functions work on a copy of the
+// captured values and canonicalization rules, so that they can be called
+// without s.mu. The caller must hold s.mu.
+func (s *MockServer) normalizers() (canonReq, canonWant func(proto.Message)) {
+	snapshot := &MockServer{vars: make(map[string]string, len(s.vars))}
+	for name, v := range s.vars {
+		snapshot.vars[name] = v
+	}
+	for _, r := range s.canonRules {
+		r := *r
+		snapshot.canonRules = append(snapshot.canonRules, &r)
+	}
+	return snapshot.canonicalize, snapshot.normalize
+}
+
+// normalize prepares an expected request for comparison, replacing
+// placeholders and applying the canonicalization rules. The caller must hold
+// s.mu.
+func (s *MockServer) normalize(m proto.Message) {
+	s.bind(m)
+	s.canonicalize(m)
+}
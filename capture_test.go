@@ -0,0 +1,182 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func TestCaptureAutoID(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	srv.ExpectCommit(nil).Returns(&pb.CommitResponse{
+		WriteResults: []*pb.WriteResult{{UpdateTime: aTimestamp}},
+		CommitTime:   aTimestamp,
+	}).Capture("doc", "writes.0.update.name")
+	srv.ExpectBatchGetDocuments(&pb.BatchGetDocumentsRequest{
+		Database:  testDB,
+		Documents: []string{Var("doc")},
+	}).Streams(&pb.BatchGetDocumentsResponse{
+		Result: &pb.BatchGetDocumentsResponse_Found{Found: &pb.Document{
+			Name:       Var("doc"),
+			Fields:     map[string]*pb.Value{"a": intValue(1)},
+			CreateTime: aTimestamp,
+			UpdateTime: aTimestamp,
+		}},
+		ReadTime: aTimestamp,
+	})
+
+	ref, _, err := client.Collection("C").Add(ctx, map[string]interface{}{"a": 1})
+	assert.Nil(err)
+	name, ok := srv.Captured("doc")
+	assert.True(ok)
+	assert.Equal(ref.Path, name)
+
+	snap, err := ref.Get(ctx)
+	assert.Nil(err)
+	assert.Equal(ref.ID, snap.Ref.ID)
+	assert.Equal(int64(1), snap.Data()["a"])
+	assert.Nil(srv.CheckExpectations())
+}
+
+func TestCaptureTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	srv.ExpectBeginTransaction(nil).Returns(&pb.BeginTransactionResponse{
+		Transaction: []byte("txn-" + Var("db")),
+	}).Capture("db", "database")
+	srv.ExpectCommit(&pb.CommitRequest{
+		Database:    Var("db"),
+		Transaction: []byte("txn-" + Var("db")),
+	}).Returns(&pb.CommitResponse{}).Capture("txn", "transaction")
+
+	resp, err := srv.BeginTransaction(ctx, &pb.BeginTransactionRequest{Database: "d"})
+	assert.Nil(err)
+	assert.Equal([]byte("txn-d"), resp.Transaction)
+
+	_, err = srv.Commit(ctx, &pb.CommitRequest{Database: "d", Transaction: resp.Transaction})
+	assert.Nil(err)
+	txn, ok := srv.Captured("txn")
+	assert.True(ok)
+	assert.Equal("txn-d", txn)
+
+	// test placeholders of names not captured yet do not match
+	srv.ExpectRollback(&pb.RollbackRequest{Database: Var("other")}).Returns(nil)
+	_, err = srv.Rollback(ctx, &pb.RollbackRequest{Database: "d"})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+
+	// test a missing field is reported
+	srv.ExpectCommit(nil).Returns(&pb.CommitResponse{}).Capture("txn", "transaction")
+	_, err = srv.Commit(ctx, &pb.CommitRequest{Database: "d"})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+
+	// test Reset discards captured values
+	srv.Reset()
+	_, ok = srv.Captured("txn")
+	assert.False(ok)
+}
+
+func TestFieldValue(t *testing.T) {
+	assert := assert.New(t)
+
+	req := &pb.CommitRequest{
+		Database: "d",
+		Writes: []*pb.Write{{
+			Operation: &pb.Write_Update{Update: &pb.Document{
+				Name:   "C/a",
+				Fields: map[string]*pb.Value{"n": intValue(7)},
+			}},
+			CurrentDocument: &pb.Precondition{ConditionType: &pb.Precondition_Exists{Exists: true}},
+		}},
+		Transaction: []byte("t"),
+	}
+	tests := []struct {
+		path, want string
+	}{
+		{"database", "d"},
+		{"transaction", "t"},
+		{"writes.0.update.name", "C/a"},
+		{"writes.0.update.fields.n.integer_value", "7"},
+		{"writes.0.current_document.exists", "true"},
+	}
+	for _, test := range tests {
+		got, err := fieldValue(req, test.path)
+		assert.Nil(err, test.path)
+		assert.Equal(test.want, got, test.path)
+	}
+
+	for _, path := range []string{
+		"nope",
+		"writes",
+		"writes.1.update.name",
+		"writes.x",
+		"writes.0.update",
+		"writes.0.delete",
+		"writes.0.update.fields.m",
+		"database.x",
+	} {
+		_, err := fieldValue(req, path)
+		assert.NotNil(err, path)
+	}
+}
+
+func TestCapturedInMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	// test a matcher can call back into the server without deadlocking
+	srv.ExpectBeginTransaction(nil).Returns(&pb.BeginTransactionResponse{Transaction: []byte("t1")}).
+		Capture("db", "database")
+	srv.ExpectCommit(nil).Matching(MatcherFunc(func(req proto.Message) bool {
+		db, ok := srv.Captured("db")
+		return ok && req.(*pb.CommitRequest).Database == db
+	})).Returns(&pb.CommitResponse{})
+	srv.AddCanonRule("uses-server", func(req proto.Message) {
+		srv.Captured("db")
+	})
+
+	_, err = srv.BeginTransaction(ctx, &pb.BeginTransactionRequest{Database: testDB})
+	assert.Nil(err)
+	_, err = srv.Commit(ctx, &pb.CommitRequest{Database: testDB})
+	assert.Nil(err)
+	assert.Nil(srv.CheckExpectations())
+}
+
+func TestPlaceholderInRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	srv.ExpectGetDocument(&pb.GetDocumentRequest{Name: "a"}).Returns(&pb.Document{Name: "a"}).
+		Capture("id", "name")
+	srv.ExpectGetDocument(&pb.GetDocumentRequest{Name: "${id}"}).Returns(&pb.Document{Name: "a"})
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{Name: "a"})
+	assert.Nil(err)
+
+	// test placeholders are bound in expected requests only
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{Name: "${id}"})
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), `name: got "${id}", want "a"`)
+	}
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{Name: "a"})
+	assert.Nil(err)
+	assert.Nil(srv.CheckExpectations())
+}
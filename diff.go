@@ -0,0 +1,336 @@
+package mockfs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// diff returns one line per field that differs between got and want, in the
+// form "path: got X, want Y". If partial is true, only the fields populated in
+// want are compared, as done by the Partial matcher.
+func diff(got, want proto.Message, partial bool) []string {
+	if !sameType(got, want) {
+		return []string{fmt.Sprintf("type: got %T, want %T", got, want)}
+	}
+	var d differ
+	d.partial = partial
+	d.messages("", proto.MessageReflect(got), proto.MessageReflect(want))
+	return d.lines
+}
+
+type differ struct {
+	partial bool
+	lines   []string
+}
+
+func (d *differ) add(path string, got, want string) {
+	d.lines = append(d.lines, fmt.Sprintf("%s: got %s, want %s", path, got, want))
+}
+
+func (d *differ) messages(path string, got, want protoreflect.Message) {
+	if v, ok := want.Interface().(*pb.Value); ok {
+		if g := got.Interface().(*pb.Value); !proto.Equal(g, v) && !(d.partial && partialEqual(want, got)) {
+			d.add(path, formatValue(g), formatValue(v))
+		}
+		return
+	}
+	fields := want.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if d.partial && !want.Has(fd) {
+			continue
+		}
+		if !got.Has(fd) && !want.Has(fd) {
+			continue
+		}
+		fpath := joinPath(path, string(fd.Name()))
+		switch {
+		case fd.IsList():
+			d.lists(fpath, fd, got.Get(fd).List(), want.Get(fd).List())
+		case fd.IsMap():
+			d.maps(fpath, fd, got.Get(fd).Map(), want.Get(fd).Map())
+		case !got.Has(fd):
+			d.add(fpath, formatUnset(fd, got), formatField(fd, want.Get(fd)))
+		case !want.Has(fd):
+			d.add(fpath, formatField(fd, got.Get(fd)), formatUnset(fd, want))
+		default:
+			d.values(fpath, fd, got.Get(fd), want.Get(fd))
+		}
+	}
+}
+
+func (d *differ) lists(path string, fd protoreflect.FieldDescriptor, got, want protoreflect.List) {
+	n := got.Len()
+	if want.Len() > n {
+		n = want.Len()
+	}
+	for i := 0; i < n; i++ {
+		epath := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= got.Len():
+			d.add(epath, "<missing>", formatField(fd, want.Get(i)))
+		case i >= want.Len():
+			d.add(epath, formatField(fd, got.Get(i)), "<missing>")
+		default:
+			d.values(epath, fd, got.Get(i), want.Get(i))
+		}
+	}
+}
+
+func (d *differ) maps(path string, fd protoreflect.FieldDescriptor, got, want protoreflect.Map) {
+	var keys []protoreflect.MapKey
+	want.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	if !d.partial {
+		got.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+			if !want.Has(k) {
+				keys = append(keys, k)
+			}
+			return true
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	vd := fd.MapValue()
+	for _, k := range keys {
+		kpath := fmt.Sprintf("%s[%s]", path, strconv.Quote(k.String()))
+		switch {
+		case !got.Has(k):
+			d.add(kpath, "<missing>", formatField(vd, want.Get(k)))
+		case !want.Has(k):
+			d.add(kpath, formatField(vd, got.Get(k)), "<missing>")
+		default:
+			d.values(kpath, vd, got.Get(k), want.Get(k))
+		}
+	}
+}
+
+// values compares a single (non-repeated) value of field fd.
+func (d *differ) values(path string, fd protoreflect.FieldDescriptor, got, want protoreflect.Value) {
+	if fd.Message() != nil {
+		d.messages(path, got.Message(), want.Message())
+		return
+	}
+	if !got.Equal(want) {
+		d.add(path, formatField(fd, got), formatField(fd, want))
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// formatUnset formats field fd, which is not populated in m. Scalars are shown
+// with their default value.
+func formatUnset(fd protoreflect.FieldDescriptor, m protoreflect.Message) string {
+	if fd.Message() != nil || fd.ContainingOneof() != nil {
+		return "<unset>"
+	}
+	return formatField(fd, m.Get(fd))
+}
+
+// formatField formats a single value of field fd.
+func formatField(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		m := v.Message().Interface()
+		if pv, ok := m.(*pb.Value); ok {
+			return formatValue(pv)
+		}
+		return "{" + strings.TrimSpace(proto.CompactTextString(proto.MessageV1(m))) + "}"
+	case protoreflect.StringKind:
+		return strconv.Quote(v.String())
+	case protoreflect.BytesKind:
+		return fmt.Sprintf("%q", v.Bytes())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// formatValue formats a Firestore value in a compact, readable form, such as
+// {a: 1, b: ["x", true]}.
+func formatValue(v *pb.Value) string {
+	switch vt := v.GetValueType().(type) {
+	case nil:
+		return "<nil>"
+	case *pb.Value_NullValue:
+		return "null"
+	case *pb.Value_BooleanValue:
+		return strconv.FormatBool(vt.BooleanValue)
+	case *pb.Value_IntegerValue:
+		return strconv.FormatInt(vt.IntegerValue, 10)
+	case *pb.Value_DoubleValue:
+		return strconv.FormatFloat(vt.DoubleValue, 'g', -1, 64)
+	case *pb.Value_TimestampValue:
+		return vt.TimestampValue.AsTime().Format(time.RFC3339Nano)
+	case *pb.Value_StringValue:
+		return strconv.Quote(vt.StringValue)
+	case *pb.Value_BytesValue:
+		return fmt.Sprintf("b%q", vt.BytesValue)
+	case *pb.Value_ReferenceValue:
+		return "ref(" + shortName(vt.ReferenceValue) + ")"
+	case *pb.Value_GeoPointValue:
+		return fmt.Sprintf("geo(%g, %g)", vt.GeoPointValue.GetLatitude(), vt.GeoPointValue.GetLongitude())
+	case *pb.Value_ArrayValue:
+		parts := make([]string, len(vt.ArrayValue.GetValues()))
+		for i, e := range vt.ArrayValue.GetValues() {
+			parts[i] = formatValue(e)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case *pb.Value_MapValue:
+		return formatFields(vt.MapValue.GetFields())
+	default:
+		return proto.CompactTextString(v)
+	}
+}
+
+// formatFields formats a map of Firestore values with sorted keys.
+func formatFields(fields map[string]*pb.Value) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + ": " + formatValue(fields[k])
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// shortName returns a document name relative to its database's documents
+// root, such as "C/d".
+func shortName(name string) string {
+	if i := strings.Index(name, "/documents/"); i >= 0 {
+		return name[i+len("/documents/"):]
+	}
+	return name
+}
+
+// writesSummary describes the write operations in req, one per line, or
+// returns nil if req carries no writes.
+func writesSummary(req proto.Message) []string {
+	var writes []*pb.Write
+	switch req := req.(type) {
+	case *pb.CommitRequest:
+		writes = req.Writes
+	case *pb.BatchWriteRequest:
+		writes = req.Writes
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+	lines := make([]string, len(writes))
+	for i, w := range writes {
+		lines[i] = fmt.Sprintf("[%d] %s", i, formatWrite(w))
+	}
+	return lines
+}
+
+// formatWrite describes a single write, such as
+// `update C/d {a: 1} mask [a] if exists=false`.
+func formatWrite(w *pb.Write) string {
+	var s string
+	switch op := w.Operation.(type) {
+	case *pb.Write_Update:
+		s = "update " + shortName(op.Update.GetName()) + " " + formatFields(op.Update.GetFields())
+	case *pb.Write_Delete:
+		s = "delete " + shortName(op.Delete)
+	case *pb.Write_Transform:
+		s = "transform " + shortName(op.Transform.GetDocument()) + formatTransforms(op.Transform.GetFieldTransforms())
+	default:
+		s = "<no operation>"
+	}
+	if w.UpdateMask != nil {
+		s += " mask [" + strings.Join(w.UpdateMask.FieldPaths, ", ") + "]"
+	}
+	if len(w.UpdateTransforms) > 0 {
+		s += " transforms" + formatTransforms(w.UpdateTransforms)
+	}
+	switch c := w.GetCurrentDocument().GetConditionType().(type) {
+	case *pb.Precondition_Exists:
+		s += fmt.Sprintf(" if exists=%t", c.Exists)
+	case *pb.Precondition_UpdateTime:
+		s += " if update_time=" + c.UpdateTime.AsTime().Format(time.RFC3339Nano)
+	}
+	return s
+}
+
+func formatTransforms(fts []*pb.DocumentTransform_FieldTransform) string {
+	parts := make([]string, len(fts))
+	for i, ft := range fts {
+		var t string
+		switch tt := ft.TransformType.(type) {
+		case *pb.DocumentTransform_FieldTransform_SetToServerValue:
+			t = "server " + tt.SetToServerValue.String()
+		case *pb.DocumentTransform_FieldTransform_Increment:
+			t = "increment " + formatValue(tt.Increment)
+		case *pb.DocumentTransform_FieldTransform_Maximum:
+			t = "maximum " + formatValue(tt.Maximum)
+		case *pb.DocumentTransform_FieldTransform_Minimum:
+			t = "minimum " + formatValue(tt.Minimum)
+		case *pb.DocumentTransform_FieldTransform_AppendMissingElements:
+			t = "append " + formatValue(&pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: tt.AppendMissingElements}})
+		case *pb.DocumentTransform_FieldTransform_RemoveAllFromArray:
+			t = "remove " + formatValue(&pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: tt.RemoveAllFromArray}})
+		}
+		parts[i] = ft.FieldPath + ": " + t
+	}
+	return " [" + strings.Join(parts, ", ") + "]"
+}
+
+// describeMismatch explains why gotReq does not satisfy e: a field-level diff
+// against the expected request, when there is one, followed by a summary of
+// the writes involved. gotReq is compared as canonicalized by canonReq and the
+// expected request as canonicalized by canonWant.
+func describeMismatch(gotReq proto.Message, e *Expectation, canonReq, canonWant func(proto.Message)) string {
+	want, partial := wantMessage(e.matcher)
+	if want == nil {
+		return fmt.Sprintf("got:  %T\n%s\nwant: %s", gotReq, proto.MarshalTextString(gotReq), e.matcher)
+	}
+	gotReq, want = e.prepare(gotReq, canonReq), canonClone(want, canonWant)
+	lines := diff(gotReq, want, partial)
+	msg := fmt.Sprintf("diff (%T, got vs want):", want)
+	for _, l := range lines {
+		msg += "\n  " + l
+	}
+	if got, want := writesSummary(gotReq), writesSummary(want); got != nil || want != nil {
+		msg += "\ngot writes:"
+		for _, l := range got {
+			msg += "\n  " + l
+		}
+		msg += "\nwant writes:"
+		for _, l := range want {
+			msg += "\n  " + l
+		}
+	}
+	return msg
+}
+
+// wantMessage returns the request a matcher compares against, if any, and
+// whether the comparison is partial.
+func wantMessage(m Matcher) (proto.Message, bool) {
+	switch m := m.(type) {
+	case equalMatcher:
+		return m.want, false
+	case partialMatcher:
+		return m.want, true
+	}
+	return nil, false
+}
@@ -0,0 +1,203 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	latlng "google.golang.org/genproto/googleapis/type/latlng"
+)
+
+func intValue(i int64) *pb.Value {
+	return &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: i}}
+}
+
+func stringValue(s string) *pb.Value {
+	return &pb.Value{ValueType: &pb.Value_StringValue{StringValue: s}}
+}
+
+func TestDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	got := &pb.CommitRequest{
+		Database: testDB,
+		Writes: []*pb.Write{
+			{
+				Operation: &pb.Write_Update{Update: &pb.Document{
+					Name:   testDocs + "/C/a",
+					Fields: map[string]*pb.Value{"a": intValue(2), "c": stringValue("x")},
+				}},
+			},
+			{Operation: &pb.Write_Delete{Delete: testDocs + "/C/b"}},
+		},
+	}
+	want := &pb.CommitRequest{
+		Database: testDB,
+		Writes: []*pb.Write{
+			{
+				Operation: &pb.Write_Update{Update: &pb.Document{
+					Name:   testDocs + "/C/a",
+					Fields: map[string]*pb.Value{"a": intValue(1), "b": stringValue("y")},
+				}},
+				CurrentDocument: &pb.Precondition{ConditionType: &pb.Precondition_Exists{Exists: true}},
+			},
+		},
+		Transaction: []byte("t"),
+	}
+	assert.Equal([]string{
+		`writes[0].update.fields["a"]: got 2, want 1`,
+		`writes[0].update.fields["b"]: got <missing>, want "y"`,
+		`writes[0].update.fields["c"]: got "x", want <missing>`,
+		`writes[0].current_document: got <unset>, want {exists:true}`,
+		`writes[1]: got {delete:"projects/projectID/databases/(default)/documents/C/b"}, want <missing>`,
+		`transaction: got "", want "t"`,
+	}, diff(got, want, false))
+
+	// test partial diffs ignore fields missing from want
+	assert.Equal([]string{
+		`writes[0].update.fields["a"]: got 2, want 1`,
+		`writes[0].update.fields["b"]: got <missing>, want "y"`,
+		`writes[0].current_document: got <unset>, want {exists:true}`,
+		`writes[1]: got {delete:"projects/projectID/databases/(default)/documents/C/b"}, want <missing>`,
+		`transaction: got "", want "t"`,
+	}, diff(got, want, true))
+
+	assert.Empty(diff(got, got, false))
+	assert.Equal([]string{"type: got *firestorepb.CommitRequest, want *firestorepb.RollbackRequest"},
+		diff(got, &pb.RollbackRequest{}, false))
+	assert.Equal([]string{"read_time: got <unset>, want {seconds:1485388800}"},
+		diff(&pb.GetDocumentRequest{}, &pb.GetDocumentRequest{
+			ConsistencySelector: &pb.GetDocumentRequest_ReadTime{ReadTime: aTimestamp},
+		}, false))
+	assert.Equal([]string{"add_target.target_id: got 1, want 2"},
+		diff(
+			&pb.ListenRequest{TargetChange: &pb.ListenRequest_AddTarget{AddTarget: &pb.Target{TargetId: 1}}},
+			&pb.ListenRequest{TargetChange: &pb.ListenRequest_AddTarget{AddTarget: &pb.Target{TargetId: 2}}},
+			false,
+		))
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		v    *pb.Value
+		want string
+	}{
+		{&pb.Value{}, "<nil>"},
+		{&pb.Value{ValueType: &pb.Value_NullValue{}}, "null"},
+		{&pb.Value{ValueType: &pb.Value_BooleanValue{BooleanValue: true}}, "true"},
+		{intValue(3), "3"},
+		{&pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: 1.5}}, "1.5"},
+		{&pb.Value{ValueType: &pb.Value_TimestampValue{TimestampValue: aTimestamp}}, "2017-01-26T00:00:00Z"},
+		{stringValue("s"), `"s"`},
+		{&pb.Value{ValueType: &pb.Value_BytesValue{BytesValue: []byte("b")}}, `b"b"`},
+		{&pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: testDocs + "/C/d"}}, "ref(C/d)"},
+		{&pb.Value{ValueType: &pb.Value_GeoPointValue{GeoPointValue: &latlng.LatLng{Latitude: 1, Longitude: 2.5}}}, "geo(1, 2.5)"},
+		{&pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: []*pb.Value{intValue(1), stringValue("x")}}}}, `[1, "x"]`},
+		{&pb.Value{ValueType: &pb.Value_MapValue{MapValue: &pb.MapValue{Fields: map[string]*pb.Value{
+			"b": intValue(2),
+			"a": &pb.Value{ValueType: &pb.Value_MapValue{MapValue: &pb.MapValue{Fields: map[string]*pb.Value{"c": intValue(1)}}}},
+		}}}}, "{a: {c: 1}, b: 2}"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, formatValue(test.v))
+	}
+}
+
+func TestWritesSummary(t *testing.T) {
+	assert := assert.New(t)
+
+	req := &pb.CommitRequest{Writes: []*pb.Write{
+		{
+			Operation:  &pb.Write_Update{Update: &pb.Document{Name: testDocs + "/C/a", Fields: map[string]*pb.Value{"a": intValue(1)}}},
+			UpdateMask: &pb.DocumentMask{FieldPaths: []string{"a"}},
+			UpdateTransforms: []*pb.DocumentTransform_FieldTransform{{
+				FieldPath:     "n",
+				TransformType: &pb.DocumentTransform_FieldTransform_Increment{Increment: intValue(1)},
+			}},
+			CurrentDocument: &pb.Precondition{ConditionType: &pb.Precondition_Exists{Exists: true}},
+		},
+		{
+			Operation:       &pb.Write_Delete{Delete: testDocs + "/C/b"},
+			CurrentDocument: &pb.Precondition{ConditionType: &pb.Precondition_UpdateTime{UpdateTime: aTimestamp}},
+		},
+		{Operation: &pb.Write_Transform{Transform: &pb.DocumentTransform{
+			Document: testDocs + "/C/c",
+			FieldTransforms: []*pb.DocumentTransform_FieldTransform{
+				{FieldPath: "t", TransformType: &pb.DocumentTransform_FieldTransform_SetToServerValue{
+					SetToServerValue: pb.DocumentTransform_FieldTransform_REQUEST_TIME,
+				}},
+				{FieldPath: "x", TransformType: &pb.DocumentTransform_FieldTransform_Maximum{Maximum: intValue(5)}},
+				{FieldPath: "y", TransformType: &pb.DocumentTransform_FieldTransform_Minimum{Minimum: intValue(0)}},
+				{FieldPath: "z", TransformType: &pb.DocumentTransform_FieldTransform_AppendMissingElements{
+					AppendMissingElements: &pb.ArrayValue{Values: []*pb.Value{intValue(1)}},
+				}},
+				{FieldPath: "w", TransformType: &pb.DocumentTransform_FieldTransform_RemoveAllFromArray{
+					RemoveAllFromArray: &pb.ArrayValue{Values: []*pb.Value{intValue(2)}},
+				}},
+			},
+		}}},
+		{},
+	}}
+	assert.Equal([]string{
+		"[0] update C/a {a: 1} mask [a] transforms [n: increment 1] if exists=true",
+		"[1] delete C/b if update_time=2017-01-26T00:00:00Z",
+		"[2] transform C/c [t: server REQUEST_TIME, x: maximum 5, y: minimum 0, z: append [1], w: remove [2]]",
+		"[3] <no operation>",
+	}, writesSummary(req))
+	assert.Equal([]string{"[0] delete C/b"}, writesSummary(&pb.BatchWriteRequest{Writes: []*pb.Write{
+		{Operation: &pb.Write_Delete{Delete: testDocs + "/C/b"}},
+	}}))
+	assert.Nil(writesSummary(&pb.RollbackRequest{}))
+	assert.Nil(writesSummary(&pb.CommitRequest{}))
+	assert.Nil(writesSummary(&pb.BatchWriteRequest{}))
+}
+
+func TestDescribeMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	srv.AddRPC(&pb.CommitRequest{Database: testDB, Writes: []*pb.Write{
+		{Operation: &pb.Write_Update{Update: &pb.Document{Name: testDocs + "/C/a", Fields: map[string]*pb.Value{"a": intValue(1)}}}},
+	}}, &pb.CommitResponse{})
+	_, err = srv.popRPC(context.Background(), &pb.CommitRequest{Database: testDB, Writes: []*pb.Write{
+		{Operation: &pb.Write_Update{Update: &pb.Document{Name: testDocs + "/C/a", Fields: map[string]*pb.Value{"a": intValue(2)}}}},
+	}})
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), `mockfs.popRPC: Bad request for Commit
+diff (*firestorepb.CommitRequest, got vs want):
+  writes[0].update.fields["a"]: got 2, want 1
+got writes:
+  [0] update C/a {a: 2}
+want writes:
+  [0] update C/a {a: 1}`)
+	}
+
+	// test a commit without writes prints no writes block
+	srv.Reset()
+	srv.AddRPC(&pb.CommitRequest{Database: testDB}, &pb.CommitResponse{})
+	_, err = srv.popRPC(context.Background(), &pb.CommitRequest{Database: "x"})
+	if assert.NotNil(err) {
+		assert.NotContains(err.Error(), "writes:")
+	}
+	srv.Reset()
+
+	// test unordered mode describes every pending expectation
+	srv.SetUnordered(true)
+	srv.AddRPCMatcher(MethodGetDocument, Partial(&pb.GetDocumentRequest{Name: "a"}), &pb.Document{})
+	srv.AddRPCMatcher(MethodGetDocument, DocumentNameMatches("^b$"), &pb.Document{})
+	_, err = srv.popRPC(context.Background(), &pb.GetDocumentRequest{Name: "c"})
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), `mockfs.popRPC: Bad request for GetDocument, none of 2 pending expectations matched
+[0] GetDocument expectation (want exactly 1, called 0 times)
+diff (*firestorepb.GetDocumentRequest, got vs want):
+  name: got "c", want "a"
+[1] GetDocument expectation (want exactly 1, called 0 times)
+got:  *firestorepb.GetDocumentRequest
+name: "c"
+
+want: document name matching "^b$"`)
+	}
+}
@@ -36,7 +36,7 @@ func Example_error() {
 		},
 		[]interface{}{
 			&pb.BatchGetDocumentsResponse{
-				Result:   &pb.BatchGetDocumentsResponse_Missing{path},
+				Result:   &pb.BatchGetDocumentsResponse_Missing{Missing: path},
 				ReadTime: aTimestamp,
 			},
 		},
@@ -70,7 +70,7 @@ func Example_success() {
 			Name:       path,
 			CreateTime: aTimestamp,
 			UpdateTime: aTimestamp,
-			Fields:     map[string]*pb.Value{"f": {ValueType: &pb.Value_IntegerValue{int64(1)}}},
+			Fields:     map[string]*pb.Value{"f": {ValueType: &pb.Value_IntegerValue{IntegerValue: int64(1)}}},
 		}
 	)
 	server.AddRPC(
@@ -80,7 +80,7 @@ func Example_success() {
 		},
 		[]interface{}{
 			&pb.BatchGetDocumentsResponse{
-				Result:   &pb.BatchGetDocumentsResponse_Found{pdoc},
+				Result:   &pb.BatchGetDocumentsResponse_Found{Found: pdoc},
 				ReadTime: aTimestamp2,
 			},
 		},
@@ -0,0 +1,131 @@
+package mockfs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	errdetails "google.golang.org/genproto/googleapis/rpc/errdetails"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	protoadapt "google.golang.org/protobuf/protoadapt"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrorDomain is the domain of the ErrorInfo details attached by the error
+// builders.
+const ErrorDomain = "firestore.googleapis.com"
+
+// A StatusError builds a gRPC status error with detail messages attached,
+// for use as a scripted response. Finish it with Err.
+type StatusError struct {
+	code    codes.Code
+	msg     string
+	details []protoadapt.MessageV1
+}
+
+// NewStatusError starts a status error with the given code and message.
+func NewStatusError(code codes.Code, msg string) *StatusError {
+	return &StatusError{code: code, msg: msg}
+}
+
+// WithRetryInfo attaches a RetryInfo detail asking the client to wait for
+// delay before retrying.
+func (e *StatusError) WithRetryInfo(delay time.Duration) *StatusError {
+	return e.with(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+}
+
+// WithErrorInfo attaches an ErrorInfo detail with the given reason and
+// metadata, in ErrorDomain.
+func (e *StatusError) WithErrorInfo(reason string, metadata map[string]string) *StatusError {
+	return e.with(&errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain, Metadata: metadata})
+}
+
+// WithQuotaFailure attaches a QuotaFailure detail for a single violation.
+func (e *StatusError) WithQuotaFailure(subject, description string) *StatusError {
+	return e.with(&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{
+		{Subject: subject, Description: description},
+	}})
+}
+
+// WithBadRequest attaches a BadRequest detail for a single invalid field.
+func (e *StatusError) WithBadRequest(field, description string) *StatusError {
+	return e.with(&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+		{Field: field, Description: description},
+	}})
+}
+
+func (e *StatusError) with(detail protoadapt.MessageV1) *StatusError {
+	e.details = append(e.details, detail)
+	return e
+}
+
+// Err returns the status error. Details cannot be attached to an OK status,
+// which yields a nil error.
+func (e *StatusError) Err() error {
+	st := status.New(e.code, e.msg)
+	if withDetails, err := st.WithDetails(e.details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// NotFoundError returns the NOT_FOUND error of the Firestore service for an
+// update of the missing document name.
+func NotFoundError(name string) error {
+	return NewStatusError(codes.NotFound, "No document to update: "+name).
+		WithErrorInfo("DOCUMENT_NOT_FOUND", map[string]string{"document": name}).
+		Err()
+}
+
+// AlreadyExistsError returns the ALREADY_EXISTS error of the Firestore
+// service for a create of the existing document name.
+func AlreadyExistsError(name string) error {
+	return NewStatusError(codes.AlreadyExists, "Document already exists: "+name).
+		WithErrorInfo("DOCUMENT_ALREADY_EXISTS", map[string]string{"document": name}).
+		Err()
+}
+
+// MissingIndexError returns the FAILED_PRECONDITION error of the Firestore
+// service for a query in database that needs a composite index which does not
+// exist, such as projects/p/databases/(default).
+func MissingIndexError(database string) error {
+	project := strings.TrimPrefix(database, "projects/")
+	if i := strings.Index(project, "/"); i >= 0 {
+		project = project[:i]
+	}
+	msg := fmt.Sprintf("The query requires an index. You can create it here: "+
+		"https://console.firebase.google.com/v1/r/project/%s/firestore/indexes", project)
+	return NewStatusError(codes.FailedPrecondition, msg).
+		WithErrorInfo("MISSING_INDEX", map[string]string{"database": database}).
+		Err()
+}
+
+// ContentionError returns the ABORTED error of the Firestore service for a
+// transaction that lost a conflict with another transaction. The client
+// retries the transaction.
+func ContentionError() error {
+	return NewStatusError(codes.Aborted, "Too much contention on these documents. Please try again.").
+		WithErrorInfo("TRANSACTION_CONTENTION", nil).
+		Err()
+}
+
+// QuotaExceededError returns the RESOURCE_EXHAUSTED error of the Firestore
+// service for a project over its quota, asking the client to retry after
+// delay.
+func QuotaExceededError(project string, delay time.Duration) error {
+	return NewStatusError(codes.ResourceExhausted, "Quota exceeded.").
+		WithErrorInfo("RESOURCE_EXHAUSTED", map[string]string{"project": project}).
+		WithQuotaFailure("projects/"+project, "Quota exceeded.").
+		WithRetryInfo(delay).
+		Err()
+}
+
+// InvalidArgumentError returns the INVALID_ARGUMENT error of the Firestore
+// service for a request with an invalid field.
+func InvalidArgumentError(field, description string) error {
+	return NewStatusError(codes.InvalidArgument, description).
+		WithErrorInfo("INVALID_ARGUMENT", map[string]string{"field": field}).
+		WithBadRequest(field, description).
+		Err()
+}
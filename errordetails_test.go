@@ -0,0 +1,104 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	firestore "cloud.google.com/go/firestore"
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	errdetails "google.golang.org/genproto/googleapis/rpc/errdetails"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func TestStatusError(t *testing.T) {
+	assert := assert.New(t)
+
+	err := NewStatusError(codes.Unavailable, "try later").
+		WithRetryInfo(2*time.Second).
+		WithErrorInfo("REASON", map[string]string{"k": "v"}).
+		WithQuotaFailure("projects/p", "too many").
+		WithBadRequest("f", "bad").
+		Err()
+	st := status.Convert(err)
+	assert.Equal(codes.Unavailable, st.Code())
+	assert.Equal("try later", st.Message())
+	details := st.Details()
+	if assert.Len(details, 4) {
+		assert.Equal(2*time.Second, details[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+		info := details[1].(*errdetails.ErrorInfo)
+		assert.Equal("REASON", info.Reason)
+		assert.Equal(ErrorDomain, info.Domain)
+		assert.Equal("v", info.Metadata["k"])
+		assert.Equal("projects/p", details[2].(*errdetails.QuotaFailure).Violations[0].Subject)
+		assert.Equal("f", details[3].(*errdetails.BadRequest).FieldViolations[0].Field)
+	}
+
+	assert.Nil(NewStatusError(codes.OK, "").WithRetryInfo(time.Second).Err())
+}
+
+func TestErrorBuilders(t *testing.T) {
+	assert := assert.New(t)
+
+	name := testDocs + "/C/a"
+	tests := []struct {
+		err    error
+		code   codes.Code
+		reason string
+		msg    string
+	}{
+		{NotFoundError(name), codes.NotFound, "DOCUMENT_NOT_FOUND", "No document to update: " + name},
+		{AlreadyExistsError(name), codes.AlreadyExists, "DOCUMENT_ALREADY_EXISTS", "Document already exists: " + name},
+		{MissingIndexError(testDB), codes.FailedPrecondition, "MISSING_INDEX",
+			"The query requires an index. You can create it here: https://console.firebase.google.com/v1/r/project/projectID/firestore/indexes"},
+		{ContentionError(), codes.Aborted, "TRANSACTION_CONTENTION", "Too much contention on these documents. Please try again."},
+		{QuotaExceededError("projectID", time.Second), codes.ResourceExhausted, "RESOURCE_EXHAUSTED", "Quota exceeded."},
+		{InvalidArgumentError("writes", "empty write"), codes.InvalidArgument, "INVALID_ARGUMENT", "empty write"},
+	}
+	for _, test := range tests {
+		st := status.Convert(test.err)
+		assert.Equal(test.code, st.Code(), test.msg)
+		assert.Equal(test.msg, st.Message())
+		if info, ok := st.Details()[0].(*errdetails.ErrorInfo); assert.True(ok, test.msg) {
+			assert.Equal(test.reason, info.Reason)
+		}
+	}
+
+	details := status.Convert(QuotaExceededError("projectID", time.Second)).Details()
+	if assert.Len(details, 3) {
+		assert.IsType(&errdetails.QuotaFailure{}, details[1])
+		assert.Equal(time.Second, details[2].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+	}
+	details = status.Convert(InvalidArgumentError("writes", "empty write")).Details()
+	if assert.Len(details, 2) {
+		assert.Equal("writes", details[1].(*errdetails.BadRequest).FieldViolations[0].Field)
+	}
+}
+
+func TestErrorDetailsOverGRPC(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	name := testDocs + "/C/a"
+	srv.ExpectCommit(nil).Fails(NotFoundError(name))
+	_, err = client.Collection("C").Doc("a").Update(ctx, []firestore.Update{{Path: "a", Value: 1}})
+	st := status.Convert(err)
+	assert.Equal(codes.NotFound, st.Code())
+	if details := st.Details(); assert.Len(details, 1) {
+		assert.Equal(name, details[0].(*errdetails.ErrorInfo).Metadata["document"])
+	}
+
+	// test the client retries a transaction aborted by contention
+	srv.ExpectBeginTransaction(nil).Returns(&pb.BeginTransactionResponse{Transaction: []byte("t1")})
+	srv.ExpectCommit(nil).Fails(ContentionError())
+	srv.ExpectBeginTransaction(nil).Returns(&pb.BeginTransactionResponse{Transaction: []byte("t2")})
+	srv.ExpectCommit(nil).Returns(&pb.CommitResponse{CommitTime: aTimestamp})
+	err = client.RunTransaction(ctx, func(context.Context, *firestore.Transaction) error { return nil })
+	assert.Nil(err)
+	assert.Nil(srv.CheckExpectations())
+}
@@ -0,0 +1,138 @@
+package mockfs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	metadata "google.golang.org/grpc/metadata"
+)
+
+// An Expectation is a scripted (request, response) pair registered with
+// AddRPC. By default an Expectation is matched exactly once; its methods
+// change how many times it may be matched before it is used up.
+type Expectation struct {
+	srv     *MockServer
+	method  string // empty for expectations that accept any method
+	matcher Matcher
+	wantReq proto.Message // nil unless added by AddRPC or AddRPCAdjust
+	adjust  func(gotReq proto.Message)
+	resp    interface{}
+
+	// The fields below are guarded by srv.mu.
+	min      int
+	max      int // negative for no upper bound
+	matched  int // requests that matched, not counting rejected ones
+	captures []capture
+
+	wantMD       metadata.MD // required incoming metadata
+	replyHeader  metadata.MD
+	replyTrailer metadata.MD
+
+	delay       time.Duration
+	streamDelay time.Duration
+}
+
+// newExpectation returns an expectation that is matched exactly once.
+func newExpectation(method string, m Matcher, wantReq proto.Message, resp interface{}) *Expectation {
+	return &Expectation{
+		method:  method,
+		matcher: m,
+		wantReq: wantReq,
+		resp:    resp,
+		min:     1,
+		max:     1,
+	}
+}
+
+// Times sets the number of times the expectation must be matched.
+func (e *Expectation) Times(n int) *Expectation {
+	return e.setCounts(n, n)
+}
+
+// AtLeast requires the expectation to be matched at least n times, with no
+// upper bound.
+func (e *Expectation) AtLeast(n int) *Expectation {
+	return e.setCounts(n, -1)
+}
+
+// AtMost allows the expectation to be matched up to n times, including not at
+// all.
+func (e *Expectation) AtMost(n int) *Expectation {
+	return e.setCounts(0, n)
+}
+
+// AnyTimes allows the expectation to be matched any number of times,
+// including not at all.
+func (e *Expectation) AnyTimes() *Expectation {
+	return e.setCounts(0, -1)
+}
+
+func (e *Expectation) setCounts(min, max int) *Expectation {
+	e.srv.mu.Lock()
+	defer e.srv.mu.Unlock()
+	e.min, e.max = min, max
+	e.srv.dropExhausted()
+	return e
+}
+
+// satisfied reports whether the expectation's minimum call count has been met.
+func (e *Expectation) satisfied() bool {
+	return e.matched >= e.min
+}
+
+// exhausted reports whether the expectation may not be matched any more.
+func (e *Expectation) exhausted() bool {
+	return e.max >= 0 && e.matched >= e.max
+}
+
+// matches reports whether gotReq satisfies the expectation's matcher. The
+// matcher is given the copy of gotReq returned by prepare, and canonWant is
+// applied to any request the matcher compares against.
+func (e *Expectation) matches(gotReq proto.Message, canonReq, canonWant func(proto.Message)) bool {
+	return matchCanon(e.matcher, e.prepare(gotReq, canonReq), canonWant)
+}
+
+// prepare returns a copy of gotReq tweaked by the adjust function, if any,
+// and then rewritten by canon. The adjust function is only called for
+// requests of the same type as wantReq. Working on a copy keeps the changes
+// made for one expectation from leaking into the comparison with the next.
+func (e *Expectation) prepare(gotReq proto.Message, canon func(proto.Message)) proto.Message {
+	req := proto.Clone(gotReq)
+	if e.adjust != nil && sameType(req, e.wantReq) {
+		e.adjust(req)
+	}
+	canon(req)
+	return req
+}
+
+// String describes the expectation, its call count constraint and the
+// request it expects.
+func (e *Expectation) String() string {
+	return fmt.Sprintf("%s\nwant: %s", e.header(), e.matcher)
+}
+
+// header describes the expectation's method, its call count constraint and
+// the number of requests that matched it.
+func (e *Expectation) header() string {
+	method := e.method
+	if method == "" {
+		method = "any method"
+	}
+	return fmt.Sprintf("%s expectation (%s, called %d times)", method, e.countString(), e.matched)
+}
+
+func (e *Expectation) countString() string {
+	switch {
+	case e.min == e.max:
+		return fmt.Sprintf("want exactly %d", e.min)
+	case e.max < 0 && e.min == 0:
+		return "want any number"
+	case e.max < 0:
+		return fmt.Sprintf("want at least %d", e.min)
+	case e.min == 0:
+		return fmt.Sprintf("want at most %d", e.max)
+	default:
+		return fmt.Sprintf("want %d to %d", e.min, e.max)
+	}
+}
@@ -0,0 +1,137 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func TestTimes(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	req := &pb.GetDocumentRequest{Name: "a"}
+	srv.AddRPC(req, &pb.Document{}).Times(3)
+	assert.NotNil(srv.CheckExpectations())
+	for i := 0; i < 3; i++ {
+		_, err = srv.popRPC(context.Background(), req)
+		assert.Nil(err)
+	}
+	assert.Nil(srv.CheckExpectations())
+	assert.Empty(srv.expectations)
+
+	// test Times(0) is dropped right away
+	srv.AddRPC(req, &pb.Document{}).Times(0)
+	assert.Empty(srv.expectations)
+}
+
+func TestAtLeast(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	reqA := &pb.GetDocumentRequest{Name: "a"}
+	reqB := &pb.GetDocumentRequest{Name: "b"}
+	srv.AddRPC(reqA, &pb.Document{Name: "a"}).AtLeast(2)
+	srv.AddRPC(reqB, &pb.Document{Name: "b"})
+
+	// test an unsatisfied expectation blocks the queue, and the mismatch
+	// is not counted as one of its calls
+	_, err = srv.popRPC(context.Background(), reqA)
+	assert.Nil(err)
+	_, err = srv.popRPC(context.Background(), reqB)
+	assert.NotNil(err)
+	err = srv.CheckExpectations()
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "2 expectations not met")
+		assert.Contains(err.Error(), "want at least 2, called 1 times")
+		assert.Contains(err.Error(), "want exactly 1, called 0 times")
+	}
+
+	// test a satisfied expectation lets later ones through
+	for i := 0; i < 2; i++ {
+		resp, err := srv.popRPC(context.Background(), reqA)
+		assert.Nil(err)
+		assert.Equal(&pb.Document{Name: "a"}, resp)
+	}
+	resp, err := srv.popRPC(context.Background(), reqB)
+	assert.Nil(err)
+	assert.Equal(&pb.Document{Name: "b"}, resp)
+	assert.Nil(srv.CheckExpectations())
+	assert.Len(srv.expectations, 1)
+}
+
+func TestAtMost(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	req := &pb.GetDocumentRequest{Name: "a"}
+	srv.AddRPC(req, &pb.Document{}).AtMost(2)
+	assert.Nil(srv.CheckExpectations())
+	for i := 0; i < 2; i++ {
+		_, err = srv.popRPC(context.Background(), req)
+		assert.Nil(err)
+	}
+	_, err = srv.popRPC(context.Background(), req)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func TestAnyTimes(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	srv.AddRPC(nil, &pb.Document{}).AnyTimes()
+	assert.Nil(srv.CheckExpectations())
+	for i := 0; i < 10; i++ {
+		_, err = srv.popRPC(context.Background(), &pb.GetDocumentRequest{})
+		assert.Nil(err)
+	}
+	assert.Nil(srv.CheckExpectations())
+	assert.Len(srv.expectations, 1)
+
+	// test a mismatch falls through to an error listing pending expectations
+	srv.Reset()
+	srv.AddRPC(&pb.GetDocumentRequest{Name: "a"}, &pb.Document{}).AnyTimes()
+	_, err = srv.popRPC(context.Background(), &pb.GetDocumentRequest{Name: "b"})
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "Bad request for GetDocument, none of 1 pending expectations matched")
+	}
+}
+
+func TestExpectationString(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	e := srv.AddRPC(&pb.CommitRequest{Database: "d"}, &pb.CommitResponse{})
+	assert.Contains(e.String(), "Commit expectation (want exactly 1, called 0 times)")
+	assert.Contains(e.String(), `database: "d"`)
+	assert.Contains(srv.AddRPC(nil, nil).String(), "any method expectation")
+
+	tests := []struct {
+		min, max int
+		want     string
+	}{
+		{2, 2, "want exactly 2"},
+		{0, -1, "want any number"},
+		{3, -1, "want at least 3"},
+		{0, 4, "want at most 4"},
+		{1, 4, "want 1 to 4"},
+	}
+	for _, test := range tests {
+		e := &Expectation{min: test.min, max: test.max}
+		assert.Equal(test.want, e.countString())
+	}
+}
@@ -0,0 +1,144 @@
+package mockfs
+
+import (
+	"fmt"
+	"math/rand"
+
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// A fault makes calls of a method fail before they are matched with the
+// expectations, or cuts their response stream short.
+type fault struct {
+	method string
+	code   codes.Code
+	count  int        // calls left to fail, or negative for no limit
+	rng    *rand.Rand // if set, calls fail at random with probability rate
+	rate   float64
+	stream bool // whether the fault cuts the stream after the first messages
+	after  int
+}
+
+// FailNext makes the next n calls of method fail with code. Failed calls are
+// recorded in the request history, but do not consume expectations.
+func (s *MockServer) FailNext(method string, n int, code codes.Code) {
+	s.addFault(&fault{method: method, code: code, count: n})
+}
+
+// FailRate makes calls of method fail with code with the given probability,
+// between 0 and 1, until the faults are cleared. The calls to fail are drawn
+// from a random source seeded with seed, so a test fails the same calls on
+// every run. Failed calls are recorded in the request history, but do not
+// consume expectations.
+func (s *MockServer) FailRate(method string, rate float64, code codes.Code, seed int64) {
+	s.addFault(&fault{method: method, code: code, count: -1, rng: rand.New(rand.NewSource(seed)), rate: rate})
+}
+
+// FailStreamAfter makes the next call of the streaming method fail with code
+// after k messages have been sent. The call is matched with the expectations
+// as usual, and the scripted messages after the first k are dropped. The
+// fault is only used up by a call that matches an expectation scripting a
+// stream of messages. Naming a method that is not server-streaming is
+// reported as a misuse, like an invalid response passed to AddRPC.
+func (s *MockServer) FailStreamAfter(method string, k int, code codes.Code) {
+	if !rpcTypes[method].stream {
+		s.misuse(fmt.Sprintf("mockfs.FailStreamAfter: %s is not a streaming method", method))
+		return
+	}
+	s.addFault(&fault{method: method, code: code, count: 1, after: k, stream: true})
+}
+
+// ClearFaults removes all faults added with FailNext, FailRate and
+// FailStreamAfter.
+func (s *MockServer) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+func (s *MockServer) addFault(f *fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, f)
+}
+
+// injectFaults applies the faults of method to a call before it is matched.
+// It returns the error for a call failed outright.
+func (s *MockServer) injectFaults(method string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.dropUsedFaults()
+	for _, f := range s.faults {
+		if f.method != method || f.stream || f.count == 0 {
+			continue
+		}
+		if f.rng != nil && f.rng.Float64() >= f.rate {
+			continue
+		}
+		if f.count > 0 {
+			f.count--
+		}
+		return f.err()
+	}
+	return nil
+}
+
+// cutStream applies the next stream fault of method, if any, to the scripted
+// stream resp of a matched call. Other responses are returned unchanged and
+// leave the fault in place.
+func (s *MockServer) cutStream(method string, resp interface{}) interface{} {
+	if _, ok := resp.([]interface{}); !ok {
+		return resp
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.dropUsedFaults()
+	for _, f := range s.faults {
+		if f.method == method && f.stream && f.count > 0 {
+			f.count--
+			return f.cut(resp)
+		}
+	}
+	return resp
+}
+
+// dropUsedFaults removes the faults that will not fail any more calls. The
+// caller must hold s.mu.
+func (s *MockServer) dropUsedFaults() {
+	kept := s.faults[:0]
+	for _, f := range s.faults {
+		if f.count != 0 {
+			kept = append(kept, f)
+		}
+	}
+	for i := len(kept); i < len(s.faults); i++ {
+		s.faults[i] = nil
+	}
+	s.faults = kept
+}
+
+func (f *fault) err() error {
+	return status.Errorf(f.code, "mockfs: injected fault for %s", f.method)
+}
+
+// cut applies a stream fault to a scripted stream, keeping the first f.after
+// messages and then failing.
+func (f *fault) cut(resp interface{}) interface{} {
+	values := resp.([]interface{})
+	var cut []interface{}
+	sent := 0
+	for _, v := range values {
+		if _, ok := v.(error); ok {
+			break
+		}
+		if _, ok := v.(pause); !ok {
+			if sent == f.after {
+				break
+			}
+			sent++
+		}
+		cut = append(cut, v)
+	}
+	return append(cut, f.err())
+}
@@ -0,0 +1,136 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	errors "github.com/weathersource/go-errors"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func TestFailNext(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	srv.FailNext(MethodGetDocument, 2, codes.Unavailable)
+	srv.ExpectGetDocument(nil).Returns(&pb.Document{Name: "a"})
+	for i := 0; i < 2; i++ {
+		_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{})
+		assert.Equal(codes.Unavailable, status.Code(err))
+	}
+	doc, err := srv.GetDocument(ctx, &pb.GetDocumentRequest{})
+	assert.Nil(err)
+	assert.Equal("a", doc.Name)
+	calls := srv.CallsFor(MethodGetDocument)
+	if assert.Len(calls, 3) {
+		assert.Equal(codes.Unavailable, status.Code(calls[0].Err))
+		assert.Nil(calls[2].Err)
+	}
+
+	// test faults reach the client, leaving the expectations untouched
+	path := testDocs + "/C/a"
+	srv.FailNext(MethodBatchGetDocuments, 1, codes.Unavailable)
+	srv.ExpectBatchGetDocuments(nil).Streams(&pb.BatchGetDocumentsResponse{
+		Result:   &pb.BatchGetDocumentsResponse_Missing{Missing: path},
+		ReadTime: aTimestamp,
+	})
+	_, err = client.Collection("C").Doc("a").Get(ctx)
+	assert.Equal(codes.Unavailable, status.Code(err))
+	_, err = client.Collection("C").Doc("a").Get(ctx)
+	assert.Equal(codes.NotFound, status.Code(err))
+	assert.Nil(srv.CheckExpectations())
+}
+
+func TestFailRate(t *testing.T) {
+	assert := assert.New(t)
+
+	failures := func(rate float64, seed int64) []bool {
+		srv := &MockServer{}
+		srv.FailRate(MethodCommit, rate, codes.Aborted, seed)
+		var failed []bool
+		for i := 0; i < 20; i++ {
+			err := srv.injectFaults(MethodCommit)
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+	count := func(failed []bool) int {
+		n := 0
+		for _, f := range failed {
+			if f {
+				n++
+			}
+		}
+		return n
+	}
+
+	assert.Equal(failures(0.5, 1), failures(0.5, 1))
+	assert.NotEqual(failures(0.5, 1), failures(0.5, 2))
+	n := count(failures(0.5, 1))
+	assert.True(n > 0 && n < 20)
+	assert.Equal(0, count(failures(0, 1)))
+	assert.Equal(20, count(failures(1, 1)))
+
+	// test the faults can be cleared
+	_, srv, err := New()
+	assert.Nil(err)
+	srv.FailRate(MethodCommit, 1, codes.ResourceExhausted, 1)
+	_, err = srv.Commit(context.Background(), &pb.CommitRequest{})
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+	srv.ClearFaults()
+	srv.ExpectCommit(nil).Returns(&pb.CommitResponse{})
+	_, err = srv.Commit(context.Background(), &pb.CommitRequest{})
+	assert.Nil(err)
+}
+
+func TestFailStreamAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	resps := []*pb.RunQueryResponse{{ReadTime: aTimestamp}, {ReadTime: aTimestamp}, {ReadTime: aTimestamp}}
+
+	srv.FailStreamAfter(MethodRunQuery, 1, codes.Unavailable)
+	srv.ExpectRunQuery(nil).Streams(resps...).Times(2)
+	qs := &contextRunQueryServer{ctx: context.Background()}
+	err = srv.RunQuery(&pb.RunQueryRequest{}, qs)
+	assert.Equal(codes.Unavailable, status.Code(err))
+	assert.Equal(1, qs.sent)
+	calls := srv.CallsFor(MethodRunQuery)
+	assert.Len(calls[0].Response, 1)
+
+	// test the fault only applies to the next stream
+	qs = &contextRunQueryServer{ctx: context.Background()}
+	err = srv.RunQuery(&pb.RunQueryRequest{}, qs)
+	assert.Nil(err)
+	assert.Equal(3, qs.sent)
+
+	// test the fault is kept for the next matching stream
+	ft := &fakeT{}
+	srv.SetReporter(ft)
+	srv.FailStreamAfter(MethodRunQuery, 1, codes.Unavailable)
+	srv.ExpectRunQuery(&pb.RunQueryRequest{Parent: "a"}).Fails(errors.NewPermissionDeniedError(""))
+	srv.ExpectRunQuery(&pb.RunQueryRequest{Parent: "a"}).Streams(resps...)
+	err = srv.RunQuery(&pb.RunQueryRequest{Parent: "b"}, &contextRunQueryServer{ctx: context.Background()})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	err = srv.RunQuery(&pb.RunQueryRequest{Parent: "a"}, &contextRunQueryServer{ctx: context.Background()})
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+	qs = &contextRunQueryServer{ctx: context.Background()}
+	err = srv.RunQuery(&pb.RunQueryRequest{Parent: "a"}, qs)
+	assert.Equal(codes.Unavailable, status.Code(err))
+	assert.Equal(1, qs.sent)
+	assert.Len(ft.errors, 1)
+
+	// test unary methods are rejected
+	srv.FailStreamAfter(MethodCommit, 1, codes.Unavailable)
+	if assert.Len(ft.errors, 2) {
+		assert.Contains(ft.errors[1], "mockfs.FailStreamAfter: Commit is not a streaming method")
+	}
+	assert.Empty(srv.faults)
+}
@@ -0,0 +1,92 @@
+package mockfs
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	metadata "google.golang.org/grpc/metadata"
+)
+
+// A Call records a request received by the MockServer and the outcome of
+// handling it.
+type Call struct {
+	// Method is the name of the RPC method, one of the Method constants.
+	Method string
+	// Request is a copy of the request as received, before any adjust
+	// function or canonicalization was applied.
+	Request proto.Message
+	// Metadata is the incoming gRPC metadata of the call.
+	Metadata metadata.MD
+	// Time is when the request was received.
+	Time time.Time
+	// Response is the response returned for unary methods. For streaming
+	// methods it is a []interface{} holding the messages that were sent.
+	Response interface{}
+	// Err is the error returned to the client, if any.
+	Err error
+}
+
+// Calls returns every request received by the server, in the order they
+// arrived.
+func (s *MockServer) Calls() []Call {
+	return s.filterCalls(func(Call) bool { return true })
+}
+
+// CallsFor returns the requests received for the named RPC method, in the
+// order they arrived.
+func (s *MockServer) CallsFor(method string) []Call {
+	return s.filterCalls(func(c Call) bool { return c.Method == method })
+}
+
+// CallsForDocument returns the requests that read or wrote the document at
+// path, in the order they arrived. The path may be a full document name or
+// relative to the database's documents root, such as "C/d".
+func (s *MockServer) CallsForDocument(path string) []Call {
+	return s.filterCalls(func(c Call) bool {
+		for _, name := range documentNames(c.Request) {
+			if name == path || strings.HasSuffix(name, "/documents/"+path) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (s *MockServer) filterCalls(keep func(Call) bool) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []Call
+	for _, c := range s.calls {
+		if keep(*c) {
+			calls = append(calls, *c)
+		}
+	}
+	return calls
+}
+
+// record adds req, received with ctx, to the server's history. The returned
+// Call is completed by finish.
+func (s *MockServer) record(ctx context.Context, req proto.Message) *Call {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c := &Call{
+		Method:   methodOf(req),
+		Request:  proto.Clone(req),
+		Metadata: md.Copy(),
+		Time:     time.Now(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, c)
+	return c
+}
+
+// finish records the outcome of c and returns err.
+func (s *MockServer) finish(c *Call, resp interface{}, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Response = resp
+	c.Err = err
+	return err
+}
@@ -0,0 +1,116 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	assert "github.com/stretchr/testify/assert"
+	errors "github.com/weathersource/go-errors"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func TestCalls(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+	start := time.Now()
+
+	commitResp := &pb.CommitResponse{
+		WriteResults: []*pb.WriteResult{{UpdateTime: aTimestamp}},
+	}
+	srv.AddRPC(nil, commitResp).Times(2)
+	srv.AddRPC(nil, errors.NewNotFoundError("")).AnyTimes()
+	_, err = client.Collection("C").Doc("a").Set(ctx, map[string]int{"a": 1})
+	assert.Nil(err)
+	_, err = client.Collection("C").Doc("x").Delete(ctx)
+	assert.Nil(err)
+	_, err = client.Collection("C").Doc("x").Get(ctx)
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	calls := srv.Calls()
+	if !assert.Len(calls, 3) {
+		return
+	}
+	assert.Equal(MethodCommit, calls[0].Method)
+	assert.Equal(MethodCommit, calls[1].Method)
+	assert.Equal(MethodBatchGetDocuments, calls[2].Method)
+	assert.True(!calls[0].Time.Before(start))
+	assert.True(!calls[1].Time.Before(calls[0].Time))
+	assert.Equal(commitResp, calls[0].Response)
+	assert.Nil(calls[0].Err)
+	assert.Equal(codes.NotFound, status.Code(calls[2].Err))
+	assert.Nil(calls[2].Response)
+
+	// test metadata is recorded
+	assert.Equal(
+		[]string{"projects/projectID/databases/(default)"},
+		calls[0].Metadata.Get("google-cloud-resource-prefix"),
+	)
+
+	// test the second Commit deleted C/x
+	commits := srv.CallsFor(MethodCommit)
+	if assert.Len(commits, 2) {
+		writes := commits[1].Request.(*pb.CommitRequest).Writes
+		if assert.Len(writes, 1) {
+			assert.Equal("projects/projectID/databases/(default)/documents/C/x", writes[0].GetDelete())
+		}
+	}
+
+	// test filtering by document
+	assert.Len(srv.CallsForDocument("C/x"), 2)
+	assert.Len(srv.CallsForDocument("projects/projectID/databases/(default)/documents/C/a"), 1)
+	assert.Len(srv.CallsForDocument("x"), 0)
+	assert.Len(srv.CallsFor(MethodRollback), 0)
+
+	srv.Reset()
+	assert.Empty(srv.Calls())
+}
+
+func TestCallsStream(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	qs := RunQueryServer{}
+	first := &pb.RunQueryResponse{Transaction: []byte("t")}
+	srv.AddRPC(nil, []interface{}{first, errors.NewUnavailableError("")})
+	err = srv.RunQuery(&pb.RunQueryRequest{Parent: "p"}, &qs)
+	assert.Equal(codes.Unavailable, status.Code(err))
+
+	calls := srv.Calls()
+	if assert.Len(calls, 1) {
+		assert.Equal(MethodRunQuery, calls[0].Method)
+		assert.True(proto.Equal(&pb.RunQueryRequest{Parent: "p"}, calls[0].Request))
+		assert.Equal([]interface{}{first}, calls[0].Response)
+		assert.Equal(err, calls[0].Err)
+	}
+
+	// test unexpected calls are recorded
+	srv.RunQuery(&pb.RunQueryRequest{}, &qs)
+	calls = srv.Calls()
+	if assert.Len(calls, 2) {
+		assert.Equal(codes.FailedPrecondition, status.Code(calls[1].Err))
+	}
+}
+
+func TestCallsRequestCopy(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	req := &pb.CommitRequest{Transaction: []byte("t")}
+	srv.AddRPCAdjust(&pb.CommitRequest{}, &pb.CommitResponse{}, func(gotReq proto.Message) {
+		gotReq.(*pb.CommitRequest).Transaction = nil
+	})
+	_, err = srv.Commit(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal([]byte("t"), srv.Calls()[0].Request.(*pb.CommitRequest).Transaction)
+}
@@ -0,0 +1,106 @@
+package mockfs
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Names of the canonicalization rules added by the Ignore methods. They can
+// be switched off again with SetCanonRuleEnabled.
+const (
+	CanonIgnoreTransaction = "ignore-transaction"
+	CanonIgnoreReadTime    = "ignore-read-time"
+	// CanonIgnoreAutoID is the prefix of the rule names added by IgnoreAutoID,
+	// which are followed by the collection ID.
+	CanonIgnoreAutoID = "ignore-auto-id:"
+)
+
+// IgnoreTransaction makes matching disregard the transaction IDs carried by
+// requests, including the transaction to retry in BeginTransaction options.
+func (s *MockServer) IgnoreTransaction() {
+	s.addCanonRule(CanonIgnoreTransaction, clearFields(protoreflect.BytesKind, "transaction", "retry_transaction"), true)
+}
+
+// IgnoreReadTime makes matching disregard the read times chosen by the
+// client.
+func (s *MockServer) IgnoreReadTime() {
+	s.addCanonRule(CanonIgnoreReadTime, clearFields(protoreflect.MessageKind, "read_time"), true)
+}
+
+// IgnoreAutoID makes matching disregard the IDs generated by Collection.Add
+// for documents in collections with the given ID. Expected requests may use
+// "*" as the document ID of such documents.
+func (s *MockServer) IgnoreAutoID(collection string) {
+	s.addCanonRule(CanonIgnoreAutoID+collection, rewriteStrings(func(v string) string {
+		if !strings.Contains(v, collection+"/") {
+			return v
+		}
+		segs := strings.Split(v, "/")
+		for i := 0; i+1 < len(segs); i++ {
+			if segs[i] == collection && autoID.MatchString(segs[i+1]) {
+				segs[i+1] = "*"
+				i++
+			}
+		}
+		return strings.Join(segs, "/")
+	}), true)
+}
+
+// autoID matches the document IDs generated by Collection.Add.
+var autoID = regexp.MustCompile(`^[A-Za-z0-9]{20}$`)
+
+// clearFields returns a rule that clears every field of the given kind with
+// one of names, wherever it occurs in a request.
+func clearFields(kind protoreflect.Kind, names ...protoreflect.Name) CanonRule {
+	return func(req proto.Message) {
+		walk(req, func(m proto.Message) {
+			mr := proto.MessageReflect(m)
+			fields := mr.Descriptor().Fields()
+			for _, name := range names {
+				if fd := fields.ByName(name); fd != nil && fd.Kind() == kind && mr.Has(fd) {
+					mr.Clear(fd)
+				}
+			}
+		})
+	}
+}
+
+// rewriteStrings returns a rule that replaces every string in a request,
+// including the elements of repeated fields, with the result of calling fn.
+func rewriteStrings(fn func(string) string) CanonRule {
+	return func(req proto.Message) {
+		rewriteFields(req, func(fd protoreflect.FieldDescriptor, v protoreflect.Value) protoreflect.Value {
+			if fd.Kind() == protoreflect.StringKind {
+				return protoreflect.ValueOfString(fn(v.String()))
+			}
+			return v
+		})
+	}
+}
+
+// rewriteFields replaces the value of every populated scalar field in m and
+// the messages nested within it, including the elements of repeated fields,
+// with the result of calling fn.
+func rewriteFields(m proto.Message, fn func(fd protoreflect.FieldDescriptor, v protoreflect.Value) protoreflect.Value) {
+	walk(m, func(m proto.Message) {
+		mr := proto.MessageReflect(m)
+		fields := mr.Descriptor().Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			if fd.Message() != nil || fd.IsMap() || !mr.Has(fd) {
+				continue
+			}
+			if fd.IsList() {
+				l := mr.Mutable(fd).List()
+				for j := 0; j < l.Len(); j++ {
+					l.Set(j, fn(fd, l.Get(j)))
+				}
+				continue
+			}
+			mr.Set(fd, fn(fd, mr.Get(fd)))
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func TestIgnoreTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	srv.IgnoreTransaction()
+	srv.ExpectCommit(&pb.CommitRequest{Database: "d"}).Returns(&pb.CommitResponse{})
+	_, err = srv.Commit(ctx, &pb.CommitRequest{Database: "d", Transaction: []byte("t1")})
+	assert.Nil(err)
+
+	srv.ExpectGetDocument(&pb.GetDocumentRequest{
+		Name:                "a",
+		ConsistencySelector: &pb.GetDocumentRequest_Transaction{Transaction: []byte("t2")},
+	}).Returns(&pb.Document{})
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{
+		Name:                "a",
+		ConsistencySelector: &pb.GetDocumentRequest_Transaction{Transaction: []byte("t1")},
+	})
+	assert.Nil(err)
+
+	srv.ExpectBeginTransaction(&pb.BeginTransactionRequest{
+		Options: &pb.TransactionOptions{Mode: &pb.TransactionOptions_ReadWrite_{ReadWrite: &pb.TransactionOptions_ReadWrite{}}},
+	}).Returns(&pb.BeginTransactionResponse{})
+	_, err = srv.BeginTransaction(ctx, &pb.BeginTransactionRequest{
+		Options: &pb.TransactionOptions{Mode: &pb.TransactionOptions_ReadWrite_{ReadWrite: &pb.TransactionOptions_ReadWrite{
+			RetryTransaction: []byte("t1"),
+		}}},
+	})
+	assert.Nil(err)
+
+	// test the rule can be switched off
+	srv.SetCanonRuleEnabled(CanonIgnoreTransaction, false)
+	srv.ExpectCommit(&pb.CommitRequest{Database: "d"}).Returns(&pb.CommitResponse{})
+	_, err = srv.Commit(ctx, &pb.CommitRequest{Database: "d", Transaction: []byte("t1")})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func TestIgnoreReadTime(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	srv.IgnoreReadTime()
+	srv.ExpectRunQuery(&pb.RunQueryRequest{Parent: "p"}).Streams()
+	err = srv.RunQuery(&pb.RunQueryRequest{
+		Parent:              "p",
+		ConsistencySelector: &pb.RunQueryRequest_ReadTime{ReadTime: aTimestamp},
+	}, &RunQueryServer{})
+	assert.Nil(err)
+
+	// test the request history keeps the read time
+	calls := srv.CallsFor(MethodRunQuery)
+	assert.Len(calls, 1)
+	assert.True(proto.Equal(aTimestamp, calls[0].Request.(*pb.RunQueryRequest).GetReadTime()))
+}
+
+func TestIgnoreAutoID(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	srv.IgnoreAutoID("C")
+	srv.ExpectCommit(&pb.CommitRequest{
+		Database: testDB,
+		Writes: []*pb.Write{{
+			Operation: &pb.Write_Update{Update: &pb.Document{
+				Name:   testDocs + "/C/*",
+				Fields: map[string]*pb.Value{"a": intValue(1)},
+			}},
+			CurrentDocument: &pb.Precondition{ConditionType: &pb.Precondition_Exists{Exists: false}},
+		}},
+	}).Returns(&pb.CommitResponse{
+		WriteResults: []*pb.WriteResult{{UpdateTime: aTimestamp}},
+		CommitTime:   aTimestamp,
+	})
+	ref, _, err := client.Collection("C").Add(ctx, map[string]interface{}{"a": 1})
+	assert.Nil(err)
+	assert.Len(ref.ID, 20)
+
+	// test only auto-IDs in the named collection are ignored
+	rule := canonRuleNamed(srv, CanonIgnoreAutoID+"C")
+	tests := []struct{ in, want string }{
+		{"p/documents/C/abcdefghij0123456789", "p/documents/C/*"},
+		{"C/abcdefghij0123456789/D/abcdefghij0123456789", "C/*/D/abcdefghij0123456789"},
+		{"p/documents/C/abcdefghij0123456789/C/0123456789abcdefghij", "p/documents/C/*/C/*"},
+		{"p/documents/C/abcdefghij0123456789/C", "p/documents/C/*/C"},
+		{"p/documents/C/short", "p/documents/C/short"},
+		{"p/documents/XC/abcdefghij0123456789", "p/documents/XC/abcdefghij0123456789"},
+	}
+	for _, test := range tests {
+		req := &pb.BatchGetDocumentsRequest{Documents: []string{test.in}}
+		rule(req)
+		assert.Equal(test.want, req.Documents[0], test.in)
+	}
+}
+
+// canonRuleNamed returns the rule registered on srv under name.
+func canonRuleNamed(srv *MockServer, name string) CanonRule {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, r := range srv.canonRules {
+		if r.name == name {
+			return r.rule
+		}
+	}
+	return nil
+}
@@ -21,115 +21,286 @@ package mockfs
 
 import (
 	"context"
-	"fmt"
+	"time"
 
-	empty "google.golang.org/protobuf/types/known/emptypb"
 	pb "google.golang.org/genproto/googleapis/firestore/v1"
-	codes "google.golang.org/grpc/codes"
-	status "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
 )
 
-// GetDocument overrides the FirestoreServer GetDocument method
+var _ pb.FirestoreServer = (*MockServer)(nil)
+
+// GetDocument implements the FirestoreServer GetDocument method
 func (s *MockServer) GetDocument(ctx context.Context, req *pb.GetDocumentRequest) (*pb.Document, error) {
-	res, err := s.popRPC(req)
+	call := s.record(ctx, req)
+	res, err := s.popRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, s.finish(call, nil, err)
 	}
-	return res.(*pb.Document), nil
+	resp, ok := res.(*pb.Document)
+	if !ok {
+		return nil, s.finish(call, nil, s.badResponse(MethodGetDocument, res))
+	}
+	return resp, s.finish(call, resp, nil)
 }
 
-// Commit overrides the FirestoreServer Commit method
+// Commit implements the FirestoreServer Commit method
 func (s *MockServer) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
-	res, err := s.popRPC(req)
+	call := s.record(ctx, req)
+	res, err := s.popRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, s.finish(call, nil, err)
+	}
+	resp, ok := res.(*pb.CommitResponse)
+	if !ok {
+		return nil, s.finish(call, nil, s.badResponse(MethodCommit, res))
 	}
-	return res.(*pb.CommitResponse), nil
+	return resp, s.finish(call, resp, nil)
 }
 
-// BatchGetDocuments overrides the FirestoreServer BatchGetDocuments method
+// BatchGetDocuments implements the FirestoreServer BatchGetDocuments method
 func (s *MockServer) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, bs pb.Firestore_BatchGetDocumentsServer) error {
-	res, err := s.popRPC(req)
+	call := s.record(bs.Context(), req)
+	res, err := s.popRPC(bs.Context(), req)
 	if err != nil {
-		return err
+		return s.finish(call, nil, err)
+	}
+	responses, ok := res.([]interface{})
+	if !ok {
+		return s.finish(call, nil, s.badResponse(MethodBatchGetDocuments, res))
 	}
-	responses := res.([]interface{})
+	var sent []interface{}
 	for _, res := range responses {
 		switch res := res.(type) {
 		case *pb.BatchGetDocumentsResponse:
 			if err := bs.Send(res); err != nil {
-				return err
+				return s.finish(call, sent, err)
+			}
+			sent = append(sent, res)
+		case pause:
+			if err := sleep(bs.Context(), time.Duration(res)); err != nil {
+				return s.finish(call, sent, err)
 			}
 		case error:
-			return res
+			return s.finish(call, sent, res)
 		default:
-			panic(fmt.Sprintf("mockfs.BatchGetDocuments: Bad response type: %+v", res))
+			return s.finish(call, sent, s.badResponse(MethodBatchGetDocuments, res))
 		}
 	}
-	return nil
+	return s.finish(call, sent, nil)
 }
 
-// RunQuery overrides the FirestoreServer RunQuery method
+// RunQuery implements the FirestoreServer RunQuery method
 func (s *MockServer) RunQuery(req *pb.RunQueryRequest, qs pb.Firestore_RunQueryServer) error {
-	res, err := s.popRPC(req)
-	// fmt.Println(res, err)
+	call := s.record(qs.Context(), req)
+	res, err := s.popRPC(qs.Context(), req)
 	if err != nil {
-		return err
+		return s.finish(call, nil, err)
 	}
-	responses := res.([]interface{})
+	responses, ok := res.([]interface{})
+	if !ok {
+		return s.finish(call, nil, s.badResponse(MethodRunQuery, res))
+	}
+	var sent []interface{}
 	for _, res := range responses {
 		switch res := res.(type) {
 		case *pb.RunQueryResponse:
 			if err := qs.Send(res); err != nil {
-				return err
+				return s.finish(call, sent, err)
+			}
+			sent = append(sent, res)
+		case pause:
+			if err := sleep(qs.Context(), time.Duration(res)); err != nil {
+				return s.finish(call, sent, err)
 			}
 		case error:
-			return res
+			return s.finish(call, sent, res)
 		default:
-			panic(fmt.Sprintf("mockfs.RunQuery: Bad response type: %+v", res))
+			return s.finish(call, sent, s.badResponse(MethodRunQuery, res))
 		}
 	}
-	return nil
+	return s.finish(call, sent, nil)
 }
 
-// BeginTransaction overrides the FirestoreServer BeginTransaction method
+// BeginTransaction implements the FirestoreServer BeginTransaction method
 func (s *MockServer) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
-	res, err := s.popRPC(req)
+	call := s.record(ctx, req)
+	res, err := s.popRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, s.finish(call, nil, err)
 	}
-	return res.(*pb.BeginTransactionResponse), nil
+	resp, ok := res.(*pb.BeginTransactionResponse)
+	if !ok {
+		return nil, s.finish(call, nil, s.badResponse(MethodBeginTransaction, res))
+	}
+	return resp, s.finish(call, resp, nil)
 }
 
-// Rollback overrides the FirestoreServer Rollback method
+// Rollback implements the FirestoreServer Rollback method
 func (s *MockServer) Rollback(ctx context.Context, req *pb.RollbackRequest) (*empty.Empty, error) {
-	res, err := s.popRPC(req)
+	call := s.record(ctx, req)
+	res, err := s.popRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, s.finish(call, nil, err)
+	}
+	resp, ok := res.(*empty.Empty)
+	if !ok {
+		return nil, s.finish(call, nil, s.badResponse(MethodRollback, res))
 	}
-	return res.(*empty.Empty), nil
+	return resp, s.finish(call, resp, nil)
 }
 
-// Listen overrides the FirestoreServer Listen method
+// Listen implements the FirestoreServer Listen method
 func (s *MockServer) Listen(stream pb.Firestore_ListenServer) error {
 	req, err := stream.Recv()
 	if err != nil {
 		return err
 	}
-	responses, err := s.popRPC(req)
+	call := s.record(stream.Context(), req)
+	res, err := s.popRPC(stream.Context(), req)
 	if err != nil {
-		if status.Code(err) == codes.Unknown {
-			panic(err)
-		}
-		return err
+		return s.finish(call, nil, err)
 	}
-	for _, res := range responses.([]interface{}) {
-		if err, ok := res.(error); ok {
-			return err
+	responses, ok := res.([]interface{})
+	if !ok {
+		return s.finish(call, nil, s.badResponse(MethodListen, res))
+	}
+	var sent []interface{}
+	for _, res := range responses {
+		switch res := res.(type) {
+		case *pb.ListenResponse:
+			if err := stream.Send(res); err != nil {
+				return s.finish(call, sent, err)
+			}
+			sent = append(sent, res)
+		case pause:
+			if err := sleep(stream.Context(), time.Duration(res)); err != nil {
+				return s.finish(call, sent, err)
+			}
+		case error:
+			return s.finish(call, sent, res)
+		default:
+			return s.finish(call, sent, s.badResponse(MethodListen, res))
 		}
-		if err := stream.Send(res.(*pb.ListenResponse)); err != nil {
-			return err
+	}
+	return s.finish(call, sent, nil)
+}
+
+// RunAggregationQuery implements the FirestoreServer RunAggregationQuery method
+func (s *MockServer) RunAggregationQuery(req *pb.RunAggregationQueryRequest, qs pb.Firestore_RunAggregationQueryServer) error {
+	call := s.record(qs.Context(), req)
+	res, err := s.popRPC(qs.Context(), req)
+	if err != nil {
+		return s.finish(call, nil, err)
+	}
+	responses, ok := res.([]interface{})
+	if !ok {
+		return s.finish(call, nil, s.badResponse(MethodRunAggregationQuery, res))
+	}
+	var sent []interface{}
+	for _, res := range responses {
+		switch res := res.(type) {
+		case *pb.RunAggregationQueryResponse:
+			if err := qs.Send(res); err != nil {
+				return s.finish(call, sent, err)
+			}
+			sent = append(sent, res)
+		case pause:
+			if err := sleep(qs.Context(), time.Duration(res)); err != nil {
+				return s.finish(call, sent, err)
+			}
+		case error:
+			return s.finish(call, sent, res)
+		default:
+			return s.finish(call, sent, s.badResponse(MethodRunAggregationQuery, res))
 		}
 	}
-	return nil
+	return s.finish(call, sent, nil)
+}
+
+// BatchWrite implements the FirestoreServer BatchWrite method
+func (s *MockServer) BatchWrite(ctx context.Context, req *pb.BatchWriteRequest) (*pb.BatchWriteResponse, error) {
+	call := s.record(ctx, req)
+	res, err := s.popRPC(ctx, req)
+	if err != nil {
+		return nil, s.finish(call, nil, err)
+	}
+	resp, ok := res.(*pb.BatchWriteResponse)
+	if !ok {
+		return nil, s.finish(call, nil, s.badResponse(MethodBatchWrite, res))
+	}
+	return resp, s.finish(call, resp, nil)
+}
+
+// ListDocuments implements the FirestoreServer ListDocuments method
+func (s *MockServer) ListDocuments(ctx context.Context, req *pb.ListDocumentsRequest) (*pb.ListDocumentsResponse, error) {
+	call := s.record(ctx, req)
+	res, err := s.popRPC(ctx, req)
+	if err != nil {
+		return nil, s.finish(call, nil, err)
+	}
+	resp, ok := res.(*pb.ListDocumentsResponse)
+	if !ok {
+		return nil, s.finish(call, nil, s.badResponse(MethodListDocuments, res))
+	}
+	return resp, s.finish(call, resp, nil)
+}
+
+// PartitionQuery implements the FirestoreServer PartitionQuery method
+func (s *MockServer) PartitionQuery(ctx context.Context, req *pb.PartitionQueryRequest) (*pb.PartitionQueryResponse, error) {
+	call := s.record(ctx, req)
+	res, err := s.popRPC(ctx, req)
+	if err != nil {
+		return nil, s.finish(call, nil, err)
+	}
+	resp, ok := res.(*pb.PartitionQueryResponse)
+	if !ok {
+		return nil, s.finish(call, nil, s.badResponse(MethodPartitionQuery, res))
+	}
+	return resp, s.finish(call, resp, nil)
+}
+
+// ListCollectionIds implements the FirestoreServer ListCollectionIds method
+func (s *MockServer) ListCollectionIds(ctx context.Context, req *pb.ListCollectionIdsRequest) (*pb.ListCollectionIdsResponse, error) {
+	call := s.record(ctx, req)
+	res, err := s.popRPC(ctx, req)
+	if err != nil {
+		return nil, s.finish(call, nil, err)
+	}
+	resp, ok := res.(*pb.ListCollectionIdsResponse)
+	if !ok {
+		return nil, s.finish(call, nil, s.badResponse(MethodListCollectionIds, res))
+	}
+	return resp, s.finish(call, resp, nil)
+}
+
+// The methods below are not scripted by the MockServer. They record the call
+// and fail it with Unimplemented, so that a client using them gets a clear
+// error rather than a crashed server.
+
+// UpdateDocument implements the FirestoreServer UpdateDocument method
+func (s *MockServer) UpdateDocument(ctx context.Context, req *pb.UpdateDocumentRequest) (*pb.Document, error) {
+	call := s.record(ctx, req)
+	return nil, s.finish(call, nil, s.unimplemented(req))
+}
+
+// DeleteDocument implements the FirestoreServer DeleteDocument method
+func (s *MockServer) DeleteDocument(ctx context.Context, req *pb.DeleteDocumentRequest) (*empty.Empty, error) {
+	call := s.record(ctx, req)
+	return nil, s.finish(call, nil, s.unimplemented(req))
+}
+
+// CreateDocument implements the FirestoreServer CreateDocument method
+func (s *MockServer) CreateDocument(ctx context.Context, req *pb.CreateDocumentRequest) (*pb.Document, error) {
+	call := s.record(ctx, req)
+	return nil, s.finish(call, nil, s.unimplemented(req))
+}
+
+// Write implements the FirestoreServer Write method
+func (s *MockServer) Write(stream pb.Firestore_WriteServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	call := s.record(stream.Context(), req)
+	return s.finish(call, nil, s.unimplemented(req))
 }
@@ -2,18 +2,28 @@ package mockfs
 
 import (
 	"context"
-	"fmt"
 	"testing"
 
-	empty "google.golang.org/protobuf/types/known/emptypb"
 	"github.com/stretchr/testify/assert"
 	errors "github.com/weathersource/go-errors"
 	pb "google.golang.org/genproto/googleapis/firestore/v1"
 	"google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
 )
 
-type BatchGetDocumentsServer struct {
+// serverStream stubs the grpc.ServerStream methods used by the handlers.
+type serverStream struct {
 	grpc.ServerStream
+}
+
+func (serverStream) Context() context.Context {
+	return context.Background()
+}
+
+type BatchGetDocumentsServer struct {
+	serverStream
 	resp *pb.BatchGetDocumentsResponse
 }
 
@@ -23,7 +33,7 @@ func (s *BatchGetDocumentsServer) Send(resp *pb.BatchGetDocumentsResponse) error
 }
 
 type BatchGetDocumentsServerError struct {
-	grpc.ServerStream
+	serverStream
 	resp *pb.BatchGetDocumentsResponse
 }
 
@@ -32,7 +42,7 @@ func (s *BatchGetDocumentsServerError) Send(resp *pb.BatchGetDocumentsResponse)
 }
 
 type RunQueryServer struct {
-	grpc.ServerStream
+	serverStream
 	resp *pb.RunQueryResponse
 }
 
@@ -42,7 +52,7 @@ func (s *RunQueryServer) Send(resp *pb.RunQueryResponse) error {
 }
 
 type RunQueryServerError struct {
-	grpc.ServerStream
+	serverStream
 	resp *pb.RunQueryResponse
 }
 
@@ -51,7 +61,7 @@ func (s *RunQueryServerError) Send(resp *pb.RunQueryResponse) error {
 }
 
 type ListenServer struct {
-	grpc.ServerStream
+	serverStream
 	req  *pb.ListenRequest
 	resp *pb.ListenResponse
 }
@@ -66,7 +76,7 @@ func (s *ListenServer) Recv() (*pb.ListenRequest, error) {
 }
 
 type ListenServerRError struct {
-	grpc.ServerStream
+	serverStream
 	req  *pb.ListenRequest
 	resp *pb.ListenResponse
 }
@@ -81,7 +91,7 @@ func (s *ListenServerRError) Recv() (*pb.ListenRequest, error) {
 }
 
 type ListenServerSError struct {
-	grpc.ServerStream
+	serverStream
 	req  *pb.ListenRequest
 	resp *pb.ListenResponse
 }
@@ -94,6 +104,40 @@ func (s *ListenServerSError) Recv() (*pb.ListenRequest, error) {
 	return s.req, nil
 }
 
+type RunAggregationQueryServer struct {
+	serverStream
+	resp *pb.RunAggregationQueryResponse
+}
+
+func (s *RunAggregationQueryServer) Send(resp *pb.RunAggregationQueryResponse) error {
+	s.resp = resp
+	return nil
+}
+
+type RunAggregationQueryServerError struct {
+	serverStream
+	resp *pb.RunAggregationQueryResponse
+}
+
+func (s *RunAggregationQueryServerError) Send(resp *pb.RunAggregationQueryResponse) error {
+	return errors.NewInternalError("")
+}
+
+type WriteServer struct {
+	serverStream
+	req  *pb.WriteRequest
+	resp *pb.WriteResponse
+}
+
+func (s *WriteServer) Send(resp *pb.WriteResponse) error {
+	s.resp = resp
+	return nil
+}
+
+func (s *WriteServer) Recv() (*pb.WriteRequest, error) {
+	return s.req, nil
+}
+
 func TestGetDocument(t *testing.T) {
 	assert := assert.New(t)
 	ctx := context.Background()
@@ -142,6 +186,102 @@ func TestCommit(t *testing.T) {
 	assert.NotNil(err)
 }
 
+func TestListDocuments(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	_, srv, err := New()
+	assert.Nil(err)
+
+	// test valid response
+	srv.AddRPC(
+		nil,
+		&pb.ListDocumentsResponse{},
+	)
+	resp, err := srv.ListDocuments(ctx, &pb.ListDocumentsRequest{})
+	assert.Nil(err)
+	assert.NotNil(resp)
+
+	// test error response
+	srv.AddRPC(
+		nil,
+		errors.NewInternalError(""),
+	)
+	_, err = srv.ListDocuments(ctx, &pb.ListDocumentsRequest{})
+	assert.NotNil(err)
+}
+
+func TestPartitionQuery(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	_, srv, err := New()
+	assert.Nil(err)
+
+	// test valid response
+	srv.AddRPC(
+		nil,
+		&pb.PartitionQueryResponse{},
+	)
+	resp, err := srv.PartitionQuery(ctx, &pb.PartitionQueryRequest{})
+	assert.Nil(err)
+	assert.NotNil(resp)
+
+	// test error response
+	srv.AddRPC(
+		nil,
+		errors.NewInternalError(""),
+	)
+	_, err = srv.PartitionQuery(ctx, &pb.PartitionQueryRequest{})
+	assert.NotNil(err)
+}
+
+func TestListCollectionIds(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	_, srv, err := New()
+	assert.Nil(err)
+
+	// test valid response
+	srv.AddRPC(
+		nil,
+		&pb.ListCollectionIdsResponse{},
+	)
+	resp, err := srv.ListCollectionIds(ctx, &pb.ListCollectionIdsRequest{})
+	assert.Nil(err)
+	assert.NotNil(resp)
+
+	// test error response
+	srv.AddRPC(
+		nil,
+		errors.NewInternalError(""),
+	)
+	_, err = srv.ListCollectionIds(ctx, &pb.ListCollectionIdsRequest{})
+	assert.NotNil(err)
+}
+
+func TestBatchWrite(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	_, srv, err := New()
+	assert.Nil(err)
+
+	// test valid response
+	srv.AddRPC(
+		nil,
+		&pb.BatchWriteResponse{},
+	)
+	resp, err := srv.BatchWrite(ctx, &pb.BatchWriteRequest{})
+	assert.Nil(err)
+	assert.NotNil(resp)
+
+	// test error response
+	srv.AddRPC(
+		nil,
+		errors.NewInternalError(""),
+	)
+	_, err = srv.BatchWrite(ctx, &pb.BatchWriteRequest{})
+	assert.NotNil(err)
+}
+
 func TestBatchGetDocuments(t *testing.T) {
 	assert := assert.New(t)
 	_, srv, err := New()
@@ -196,9 +336,16 @@ func TestBatchGetDocuments(t *testing.T) {
 			&pb.GetDocumentRequest{},
 		},
 	)
-	assert.Panics(func() {
-		srv.BatchGetDocuments(&pb.BatchGetDocumentsRequest{}, &bs)
-	})
+	err = srv.BatchGetDocuments(&pb.BatchGetDocumentsRequest{}, &bs)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+
+	// test wrong response type
+	srv.AddRPC(
+		nil,
+		&pb.BatchGetDocumentsResponse{},
+	)
+	err = srv.BatchGetDocuments(&pb.BatchGetDocumentsRequest{}, &bs)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
 }
 
 func TestRunQuery(t *testing.T) {
@@ -255,9 +402,74 @@ func TestRunQuery(t *testing.T) {
 			&pb.GetDocumentRequest{},
 		},
 	)
-	assert.Panics(func() {
-		srv.RunQuery(&pb.RunQueryRequest{}, &qs)
-	})
+	err = srv.RunQuery(&pb.RunQueryRequest{}, &qs)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+
+	// test wrong response type
+	srv.AddRPC(
+		nil,
+		&pb.RunQueryResponse{},
+	)
+	err = srv.RunQuery(&pb.RunQueryRequest{}, &qs)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func TestRunAggregationQuery(t *testing.T) {
+	assert := assert.New(t)
+	_, srv, err := New()
+	assert.Nil(err)
+
+	qs := RunAggregationQueryServer{}
+	qse := RunAggregationQueryServerError{}
+
+	// test valid response
+	srv.AddRPC(
+		nil,
+		[]interface{}{
+			&pb.RunAggregationQueryResponse{},
+		},
+	)
+	err = srv.RunAggregationQuery(&pb.RunAggregationQueryRequest{}, &qs)
+	assert.Nil(err)
+	assert.NotNil(qs.resp)
+
+	// test error send
+	srv.AddRPC(
+		nil,
+		[]interface{}{
+			&pb.RunAggregationQueryResponse{},
+		},
+	)
+	err = srv.RunAggregationQuery(&pb.RunAggregationQueryRequest{}, &qse)
+	assert.NotNil(err)
+
+	// test error response
+	srv.AddRPC(
+		nil,
+		errors.NewInternalError(""),
+	)
+	err = srv.RunAggregationQuery(&pb.RunAggregationQueryRequest{}, &qs)
+	assert.NotNil(err)
+
+	// test error response in batch
+	srv.AddRPC(
+		nil,
+		[]interface{}{
+			errors.NewInternalError(""),
+		},
+	)
+	err = srv.RunAggregationQuery(&pb.RunAggregationQueryRequest{}, &qs)
+	assert.NotNil(err)
+
+	// test wrong type in batch
+	srv.AddRPC(
+		nil,
+		[]interface{}{
+			&pb.RunQueryResponse{},
+		},
+	)
+	err = srv.RunAggregationQuery(&pb.RunAggregationQueryRequest{}, &qs)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
 }
 
 func TestBeginTransaction(t *testing.T) {
@@ -360,13 +572,70 @@ func TestListen(t *testing.T) {
 	err = srv.Listen(&ls)
 	assert.NotNil(err)
 
-	// test panic on unknown error
-	fmt.Println("FOOBAR")
+	// test unknown error is returned
 	srv.AddRPC(
 		nil,
 		errors.NewUnknownError(""),
 	)
-	assert.Panics(func() {
-		srv.Listen(&ls)
-	})
+	err = srv.Listen(&ls)
+	assert.Equal(codes.Unknown, status.Code(err))
+
+	// test wrong type in stream
+	srv.AddRPC(
+		nil,
+		[]interface{}{
+			&pb.RunQueryResponse{},
+		},
+	)
+	err = srv.Listen(&ls)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+
+	// test wrong response type
+	srv.AddRPC(
+		nil,
+		&pb.ListenResponse{},
+	)
+	err = srv.Listen(&ls)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func TestUnimplemented(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	_, srv, err := New()
+	assert.Nil(err)
+	ft := &fakeT{}
+	srv.SetReporter(ft)
+
+	calls := []func() error{
+		func() error { _, err := srv.DeleteDocument(ctx, &pb.DeleteDocumentRequest{Name: "p"}); return err },
+		func() error { _, err := srv.UpdateDocument(ctx, &pb.UpdateDocumentRequest{}); return err },
+		func() error { _, err := srv.CreateDocument(ctx, &pb.CreateDocumentRequest{}); return err },
+		func() error { return srv.Write(&WriteServer{req: &pb.WriteRequest{}}) },
+	}
+	methods := []string{
+		MethodDeleteDocument, MethodUpdateDocument, MethodCreateDocument, MethodWrite,
+	}
+	for i, call := range calls {
+		err := call()
+		assert.Equal(codes.Unimplemented, status.Code(err), methods[i])
+		assert.Contains(status.Convert(err).Message(), "mockfs: "+methods[i]+" is not implemented by the mock")
+	}
+	assert.Contains(ft.errors[0], `got:  *firestorepb.DeleteDocumentRequest
+name: "p"`)
+
+	history := srv.Calls()
+	if assert.Len(history, len(methods)) {
+		for i, c := range history {
+			assert.Equal(methods[i], c.Method)
+			assert.Equal(codes.Unimplemented, status.Code(c.Err))
+		}
+	}
+
+	// test a client gets an error rather than a crashed server
+	conn, err := grpc.Dial(srv.Addr, grpc.WithInsecure())
+	assert.Nil(err)
+	defer conn.Close()
+	_, err = pb.NewFirestoreClient(conn).DeleteDocument(ctx, &pb.DeleteDocumentRequest{Name: "p"})
+	assert.Equal(codes.Unimplemented, status.Code(err))
 }
@@ -0,0 +1,88 @@
+package mockfs
+
+import (
+	"context"
+	"time"
+
+	status "google.golang.org/grpc/status"
+)
+
+// A pause in the scripted messages of a stream makes the handler wait before
+// sending the following message.
+type pause time.Duration
+
+// Delay makes the server wait for d before answering a request matching the
+// expectation. For streaming methods, the wait comes before the first
+// message.
+func (e *Expectation) Delay(d time.Duration) *Expectation {
+	e.srv.mu.Lock()
+	defer e.srv.mu.Unlock()
+	e.delay = d
+	return e
+}
+
+// StreamDelay makes the server wait for d between the messages it streams in
+// answer to a request matching the expectation.
+func (e *Expectation) StreamDelay(d time.Duration) *Expectation {
+	e.srv.mu.Lock()
+	defer e.srv.mu.Unlock()
+	e.streamDelay = d
+	return e
+}
+
+// SetMethodDelay makes the server wait for d before handling each request to
+// the named RPC method, in addition to any delay of the matching expectation.
+// A zero d removes the delay.
+func (s *MockServer) SetMethodDelay(method string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.methodDelays == nil {
+		s.methodDelays = map[string]time.Duration{}
+	}
+	s.methodDelays[method] = d
+}
+
+func (s *MockServer) methodDelay(method string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.methodDelays[method]
+}
+
+// delays returns the delays of e.
+func (s *MockServer) delays(e *Expectation) (delay, streamDelay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return e.delay, e.streamDelay
+}
+
+// withPauses inserts a pause of d between the messages of a scripted stream.
+func withPauses(resp interface{}, d time.Duration) interface{} {
+	values, ok := resp.([]interface{})
+	if !ok || d <= 0 {
+		return resp
+	}
+	paused := make([]interface{}, 0, 2*len(values))
+	for i, v := range values {
+		if i > 0 {
+			paused = append(paused, pause(d))
+		}
+		paused = append(paused, v)
+	}
+	return paused
+}
+
+// sleep waits for d, or until ctx is done, in which case it returns the
+// DeadlineExceeded or Canceled status error for the call.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// contextRunQueryServer is a RunQueryServer whose context can be cancelled.
+type contextRunQueryServer struct {
+	RunQueryServer
+	ctx  context.Context
+	sent int
+}
+
+func (s *contextRunQueryServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *contextRunQueryServer) Send(resp *pb.RunQueryResponse) error {
+	s.sent++
+	return nil
+}
+
+func TestDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+
+	srv.ExpectGetDocument(nil).Returns(&pb.Document{}).Delay(20 * time.Millisecond)
+	start := time.Now()
+	_, err = srv.GetDocument(context.Background(), &pb.GetDocumentRequest{})
+	assert.Nil(err)
+	assert.True(time.Since(start) >= 20*time.Millisecond)
+
+	// test delays respect the deadline and cancellation of the call
+	srv.ExpectGetDocument(nil).Returns(&pb.Document{}).Delay(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{})
+	assert.Equal(codes.DeadlineExceeded, status.Code(err))
+	calls := srv.CallsFor(MethodGetDocument)
+	assert.Equal(codes.DeadlineExceeded, status.Code(calls[len(calls)-1].Err))
+
+	srv.ExpectGetDocument(nil).Returns(&pb.Document{}).Delay(time.Minute)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{})
+	assert.Equal(codes.Canceled, status.Code(err))
+
+	// test a client deadline ends the call over gRPC
+	srv.ExpectBatchGetDocuments(nil).Streams().Delay(time.Minute)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.Collection("C").Doc("a").Get(ctx)
+	assert.Equal(codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestStreamDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	resps := []*pb.RunQueryResponse{{ReadTime: aTimestamp}, {ReadTime: aTimestamp}, {ReadTime: aTimestamp}}
+
+	srv.ExpectRunQuery(nil).Streams(resps...).StreamDelay(10 * time.Millisecond)
+	qs := &contextRunQueryServer{ctx: context.Background()}
+	start := time.Now()
+	err = srv.RunQuery(&pb.RunQueryRequest{}, qs)
+	assert.Nil(err)
+	assert.True(time.Since(start) >= 20*time.Millisecond)
+	assert.Equal(3, qs.sent)
+	calls := srv.CallsFor(MethodRunQuery)
+	assert.Len(calls[0].Response, 3)
+
+	// test the stream stops when the call is cancelled between messages
+	srv.ExpectRunQuery(nil).Streams(resps...).StreamDelay(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	qs = &contextRunQueryServer{ctx: ctx}
+	err = srv.RunQuery(&pb.RunQueryRequest{}, qs)
+	assert.Equal(codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(1, qs.sent)
+}
+
+func TestSetMethodDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	srv.SetMethodDelay(MethodCommit, time.Minute)
+	srv.ExpectGetDocument(nil).Returns(&pb.Document{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{})
+	assert.Nil(err)
+
+	srv.ExpectCommit(nil).Returns(&pb.CommitResponse{})
+	_, err = srv.Commit(ctx, &pb.CommitRequest{})
+	assert.Equal(codes.DeadlineExceeded, status.Code(err))
+
+	// test the delay comes before matching, so the expectation is left pending
+	srv.SetMethodDelay(MethodCommit, 0)
+	_, err = srv.Commit(context.Background(), &pb.CommitRequest{})
+	assert.Nil(err)
+}
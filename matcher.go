@@ -0,0 +1,381 @@
+package mockfs
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// A Matcher decides whether an incoming request satisfies an expectation.
+// Matchers are called without the server's lock held, so they may call back
+// into the MockServer, for example to read a value with Captured.
+type Matcher interface {
+	// Matches reports whether req satisfies the matcher.
+	Matches(req proto.Message) bool
+	// String describes the requests accepted by the matcher.
+	String() string
+}
+
+// A canonMatcher compares requests with an expected request, which must have
+// its placeholders bound and be canonicalized in the same way as the requests
+// it is compared with.
+type canonMatcher interface {
+	matchesCanon(req proto.Message, canon func(proto.Message)) bool
+}
+
+// matchCanon reports whether m accepts req, canonicalizing the expected
+// request of m with canon if it has one.
+func matchCanon(m Matcher, req proto.Message, canon func(proto.Message)) bool {
+	if cm, ok := m.(canonMatcher); ok {
+		return cm.matchesCanon(req, canon)
+	}
+	return m.Matches(req)
+}
+
+// canonClone returns a copy of m rewritten by canon.
+func canonClone(m proto.Message, canon func(proto.Message)) proto.Message {
+	m = proto.Clone(m)
+	canon(m)
+	return m
+}
+
+// MatcherFunc adapts an ordinary function to the Matcher interface.
+type MatcherFunc func(req proto.Message) bool
+
+// Matches calls f(req).
+func (f MatcherFunc) Matches(req proto.Message) bool { return f(req) }
+
+// String implements Matcher.
+func (f MatcherFunc) String() string { return "matcher func" }
+
+// Any returns a Matcher that accepts every request.
+func Any() Matcher {
+	return anyMatcher{}
+}
+
+type anyMatcher struct{}
+
+func (anyMatcher) Matches(proto.Message) bool { return true }
+func (anyMatcher) String() string             { return "any request" }
+
+// Equal returns a Matcher that accepts requests equal to want, as reported by
+// proto.Equal.
+func Equal(want proto.Message) Matcher {
+	return equalMatcher{want}
+}
+
+type equalMatcher struct {
+	want proto.Message
+}
+
+func (m equalMatcher) Matches(req proto.Message) bool {
+	return sameType(req, m.want) && proto.Equal(req, m.want)
+}
+
+func (m equalMatcher) matchesCanon(req proto.Message, canon func(proto.Message)) bool {
+	return equalMatcher{canonClone(m.want, canon)}.Matches(req)
+}
+
+func (m equalMatcher) String() string {
+	return fmt.Sprintf("%T\n%s", m.want, proto.MarshalTextString(m.want))
+}
+
+// Partial returns a Matcher that only compares the fields populated in want.
+// Populated message fields are compared recursively in the same way, map
+// fields only require the keys present in want, and repeated fields must have
+// the same length with each element compared partially.
+func Partial(want proto.Message) Matcher {
+	return partialMatcher{want}
+}
+
+type partialMatcher struct {
+	want proto.Message
+}
+
+func (m partialMatcher) Matches(req proto.Message) bool {
+	if !sameType(req, m.want) {
+		return false
+	}
+	return partialEqual(
+		proto.MessageReflect(m.want),
+		proto.MessageReflect(req),
+	)
+}
+
+func (m partialMatcher) matchesCanon(req proto.Message, canon func(proto.Message)) bool {
+	return partialMatcher{canonClone(m.want, canon)}.Matches(req)
+}
+
+func (m partialMatcher) String() string {
+	return fmt.Sprintf("partial %T\n%s", m.want, proto.MarshalTextString(m.want))
+}
+
+func partialEqual(want, got protoreflect.Message) bool {
+	equal := true
+	want.Range(func(fd protoreflect.FieldDescriptor, wv protoreflect.Value) bool {
+		if !got.Has(fd) {
+			equal = false
+			return false
+		}
+		equal = partialValueEqual(fd, wv, got.Get(fd))
+		return equal
+	})
+	return equal
+}
+
+func partialValueEqual(fd protoreflect.FieldDescriptor, want, got protoreflect.Value) bool {
+	switch {
+	case fd.IsList():
+		wl, gl := want.List(), got.List()
+		if wl.Len() != gl.Len() {
+			return false
+		}
+		for i := 0; i < wl.Len(); i++ {
+			if !partialScalarEqual(fd, wl.Get(i), gl.Get(i)) {
+				return false
+			}
+		}
+		return true
+	case fd.IsMap():
+		wm, gm := want.Map(), got.Map()
+		equal := true
+		wm.Range(func(k protoreflect.MapKey, wv protoreflect.Value) bool {
+			if !gm.Has(k) {
+				equal = false
+				return false
+			}
+			equal = partialScalarEqual(fd.MapValue(), wv, gm.Get(k))
+			return equal
+		})
+		return equal
+	default:
+		return partialScalarEqual(fd, want, got)
+	}
+}
+
+// partialScalarEqual compares a single (non-repeated) value of field fd.
+func partialScalarEqual(fd protoreflect.FieldDescriptor, want, got protoreflect.Value) bool {
+	if fd.Message() != nil {
+		return partialEqual(want.Message(), got.Message())
+	}
+	return want.Equal(got)
+}
+
+// DocumentNameMatches returns a Matcher that accepts requests in which the
+// name of at least one document read or written by the request matches the
+// regular expression pattern. It panics if pattern does not compile.
+func DocumentNameMatches(pattern string) Matcher {
+	return nameMatcher{regexp.MustCompile(pattern)}
+}
+
+type nameMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m nameMatcher) Matches(req proto.Message) bool {
+	for _, name := range documentNames(req) {
+		if m.re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m nameMatcher) String() string {
+	return fmt.Sprintf("document name matching %q", m.re)
+}
+
+// BatchOf returns a Matcher that accepts BatchWriteRequests whose writes are
+// all equal to one of writes, as reported by proto.Equal. It matches however
+// the client splits writes into batches and orders them, so an expectation
+// using it usually needs AtLeast or AnyTimes.
+func BatchOf(writes ...*pb.Write) Matcher {
+	return batchMatcher{writes}
+}
+
+type batchMatcher struct {
+	writes []*pb.Write
+}
+
+func (m batchMatcher) Matches(req proto.Message) bool {
+	batch, ok := req.(*pb.BatchWriteRequest)
+	if !ok || len(batch.Writes) == 0 {
+		return false
+	}
+	for _, got := range batch.Writes {
+		if !m.contains(got) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m batchMatcher) matchesCanon(req proto.Message, canon func(proto.Message)) bool {
+	writes := make([]*pb.Write, len(m.writes))
+	for i, w := range m.writes {
+		writes[i] = canonClone(w, canon).(*pb.Write)
+	}
+	return batchMatcher{writes}.Matches(req)
+}
+
+func (m batchMatcher) contains(got *pb.Write) bool {
+	for _, w := range m.writes {
+		if proto.Equal(got, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m batchMatcher) String() string {
+	lines := make([]string, len(m.writes))
+	for i, w := range m.writes {
+		lines[i] = formatWrite(w)
+	}
+	return fmt.Sprintf("batch of writes among\n%s", strings.Join(lines, "\n"))
+}
+
+// And returns a Matcher that accepts requests accepted by all of ms.
+func And(ms ...Matcher) Matcher {
+	return andMatcher(ms)
+}
+
+type andMatcher []Matcher
+
+func (ms andMatcher) Matches(req proto.Message) bool {
+	for _, m := range ms {
+		if !m.Matches(req) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ms andMatcher) matchesCanon(req proto.Message, canon func(proto.Message)) bool {
+	for _, m := range ms {
+		if !matchCanon(m, req, canon) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ms andMatcher) String() string {
+	return joinMatchers("and", ms)
+}
+
+// Or returns a Matcher that accepts requests accepted by any of ms.
+func Or(ms ...Matcher) Matcher {
+	return orMatcher(ms)
+}
+
+type orMatcher []Matcher
+
+func (ms orMatcher) Matches(req proto.Message) bool {
+	for _, m := range ms {
+		if m.Matches(req) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms orMatcher) matchesCanon(req proto.Message, canon func(proto.Message)) bool {
+	for _, m := range ms {
+		if matchCanon(m, req, canon) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms orMatcher) String() string {
+	return joinMatchers("or", ms)
+}
+
+// Not returns a Matcher that accepts requests rejected by m.
+func Not(m Matcher) Matcher {
+	return notMatcher{m}
+}
+
+type notMatcher struct {
+	m Matcher
+}
+
+func (m notMatcher) Matches(req proto.Message) bool {
+	return !m.m.Matches(req)
+}
+
+func (m notMatcher) matchesCanon(req proto.Message, canon func(proto.Message)) bool {
+	return !matchCanon(m.m, req, canon)
+}
+
+func (m notMatcher) String() string {
+	return fmt.Sprintf("not(%s)", m.m)
+}
+
+func joinMatchers(op string, ms []Matcher) string {
+	parts := make([]string, len(ms))
+	for i, m := range ms {
+		parts[i] = m.String()
+	}
+	return fmt.Sprintf("%s(%s)", op, strings.Join(parts, ", "))
+}
+
+func sameType(a, b proto.Message) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b)
+}
+
+// documentNames returns the names of the documents read or written by req.
+func documentNames(req proto.Message) []string {
+	var names []string
+	switch req := req.(type) {
+	case *pb.GetDocumentRequest:
+		names = append(names, req.Name)
+	case *pb.BatchGetDocumentsRequest:
+		names = append(names, req.Documents...)
+	case *pb.CommitRequest:
+		for _, w := range req.Writes {
+			names = append(names, writeName(w))
+		}
+	case *pb.ListenRequest:
+		if docs := req.GetAddTarget().GetDocuments(); docs != nil {
+			names = append(names, docs.Documents...)
+		}
+	case *pb.UpdateDocumentRequest:
+		names = append(names, req.GetDocument().GetName())
+	case *pb.DeleteDocumentRequest:
+		names = append(names, req.Name)
+	case *pb.CreateDocumentRequest:
+		if req.DocumentId != "" {
+			names = append(names, req.Parent+"/"+req.CollectionId+"/"+req.DocumentId)
+		}
+	case *pb.WriteRequest:
+		for _, w := range req.Writes {
+			names = append(names, writeName(w))
+		}
+	case *pb.BatchWriteRequest:
+		for _, w := range req.Writes {
+			names = append(names, writeName(w))
+		}
+	}
+	return names
+}
+
+// writeName returns the name of the document changed by w.
+func writeName(w *pb.Write) string {
+	switch op := w.Operation.(type) {
+	case *pb.Write_Update:
+		return op.Update.GetName()
+	case *pb.Write_Delete:
+		return op.Delete
+	case *pb.Write_Transform:
+		return op.Transform.GetDocument()
+	}
+	return ""
+}
@@ -0,0 +1,219 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestMatcherFunc(t *testing.T) {
+	m := MatcherFunc(func(req proto.Message) bool {
+		return req.(*pb.GetDocumentRequest).Name == "a"
+	})
+	assert.True(t, m.Matches(&pb.GetDocumentRequest{Name: "a"}))
+	assert.False(t, m.Matches(&pb.GetDocumentRequest{Name: "b"}))
+	assert.Equal(t, "matcher func", m.String())
+}
+
+func TestAny(t *testing.T) {
+	assert.True(t, Any().Matches(&pb.CommitRequest{}))
+	assert.True(t, Any().Matches(nil))
+	assert.Equal(t, "any request", Any().String())
+}
+
+func TestEqual(t *testing.T) {
+	assert := assert.New(t)
+
+	m := Equal(&pb.GetDocumentRequest{Name: "a"})
+	assert.True(m.Matches(&pb.GetDocumentRequest{Name: "a"}))
+	assert.False(m.Matches(&pb.GetDocumentRequest{Name: "b"}))
+	assert.False(m.Matches(&pb.BatchGetDocumentsRequest{}))
+	assert.Contains(m.String(), `name: "a"`)
+}
+
+func TestPartial(t *testing.T) {
+	assert := assert.New(t)
+
+	got := &pb.CommitRequest{
+		Database: "projects/projectID/databases/(default)",
+		Writes: []*pb.Write{
+			{
+				Operation: &pb.Write_Update{
+					Update: &pb.Document{
+						Name: "projects/projectID/databases/(default)/documents/C/random",
+						Fields: map[string]*pb.Value{
+							"a": {ValueType: &pb.Value_IntegerValue{IntegerValue: 1}},
+							"b": {ValueType: &pb.Value_StringValue{StringValue: "x"}},
+						},
+					},
+				},
+				CurrentDocument: &pb.Precondition{
+					ConditionType: &pb.Precondition_Exists{Exists: false},
+				},
+			},
+		},
+		Transaction: []byte("t"),
+	}
+
+	tests := []struct {
+		want    *pb.CommitRequest
+		matches bool
+	}{
+		{&pb.CommitRequest{}, true},
+		{&pb.CommitRequest{Database: "projects/projectID/databases/(default)"}, true},
+		{&pb.CommitRequest{Database: "other"}, false},
+		{&pb.CommitRequest{Transaction: []byte("t")}, true},
+		{&pb.CommitRequest{Transaction: []byte("u")}, false},
+		// nested fields and maps only compare what is set
+		{&pb.CommitRequest{Writes: []*pb.Write{{
+			Operation: &pb.Write_Update{Update: &pb.Document{
+				Fields: map[string]*pb.Value{"a": {ValueType: &pb.Value_IntegerValue{IntegerValue: 1}}},
+			}},
+		}}}, true},
+		{&pb.CommitRequest{Writes: []*pb.Write{{
+			Operation: &pb.Write_Update{Update: &pb.Document{
+				Fields: map[string]*pb.Value{"a": {ValueType: &pb.Value_IntegerValue{IntegerValue: 2}}},
+			}},
+		}}}, false},
+		{&pb.CommitRequest{Writes: []*pb.Write{{
+			Operation: &pb.Write_Update{Update: &pb.Document{
+				Fields: map[string]*pb.Value{"c": {ValueType: &pb.Value_IntegerValue{IntegerValue: 1}}},
+			}},
+		}}}, false},
+		// oneof choices must agree
+		{&pb.CommitRequest{Writes: []*pb.Write{{
+			Operation: &pb.Write_Delete{Delete: "x"},
+		}}}, false},
+		// repeated fields must have the same length
+		{&pb.CommitRequest{Writes: []*pb.Write{{}, {}}}, false},
+	}
+	for i, test := range tests {
+		assert.Equal(test.matches, Partial(test.want).Matches(got), "test %d", i)
+	}
+	assert.False(Partial(&pb.CommitRequest{}).Matches(&pb.RollbackRequest{}))
+	assert.Contains(Partial(&pb.CommitRequest{Database: "d"}).String(), "partial *firestorepb.CommitRequest")
+}
+
+func TestDocumentNameMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	m := DocumentNameMatches(`/documents/C/[^/]+$`)
+	assert.True(m.Matches(&pb.GetDocumentRequest{Name: "projects/p/databases/d/documents/C/a"}))
+	assert.False(m.Matches(&pb.GetDocumentRequest{Name: "projects/p/databases/d/documents/D/a"}))
+	assert.True(m.Matches(&pb.BatchGetDocumentsRequest{Documents: []string{
+		"projects/p/databases/d/documents/D/a",
+		"projects/p/databases/d/documents/C/b",
+	}}))
+	assert.True(m.Matches(&pb.CommitRequest{Writes: []*pb.Write{
+		{Operation: &pb.Write_Delete{Delete: "projects/p/databases/d/documents/C/a"}},
+	}}))
+	assert.True(m.Matches(&pb.CommitRequest{Writes: []*pb.Write{
+		{Operation: &pb.Write_Transform{Transform: &pb.DocumentTransform{Document: "projects/p/databases/d/documents/C/a"}}},
+	}}))
+	assert.True(m.Matches(&pb.ListenRequest{TargetChange: &pb.ListenRequest_AddTarget{AddTarget: &pb.Target{
+		TargetType: &pb.Target_Documents{Documents: &pb.Target_DocumentsTarget{
+			Documents: []string{"projects/p/databases/d/documents/C/a"},
+		}},
+	}}}))
+	assert.True(m.Matches(&pb.UpdateDocumentRequest{Document: &pb.Document{Name: "projects/p/databases/d/documents/C/a"}}))
+	assert.True(m.Matches(&pb.DeleteDocumentRequest{Name: "projects/p/databases/d/documents/C/a"}))
+	assert.True(m.Matches(&pb.CreateDocumentRequest{
+		Parent:       "projects/p/databases/d/documents",
+		CollectionId: "C",
+		DocumentId:   "a",
+	}))
+	assert.False(m.Matches(&pb.CreateDocumentRequest{Parent: "projects/p/databases/d/documents", CollectionId: "C"}))
+	assert.True(m.Matches(&pb.BatchWriteRequest{Writes: []*pb.Write{
+		{Operation: &pb.Write_Delete{Delete: "projects/p/databases/d/documents/C/a"}},
+	}}))
+	assert.False(m.Matches(&pb.RunQueryRequest{}))
+	assert.Equal(`document name matching "/documents/C/[^/]+$"`, m.String())
+	assert.Panics(func() { DocumentNameMatches("(") })
+}
+
+func TestBatchOf(t *testing.T) {
+	assert := assert.New(t)
+
+	del := func(name string) *pb.Write {
+		return &pb.Write{Operation: &pb.Write_Delete{Delete: testDocs + "/C/" + name}}
+	}
+	m := BatchOf(del("a"), del("b"), del("c"))
+	assert.True(m.Matches(&pb.BatchWriteRequest{Writes: []*pb.Write{del("c"), del("a")}}))
+	assert.True(m.Matches(&pb.BatchWriteRequest{Writes: []*pb.Write{del("b")}}))
+	assert.False(m.Matches(&pb.BatchWriteRequest{Writes: []*pb.Write{del("a"), del("d")}}))
+	assert.False(m.Matches(&pb.BatchWriteRequest{}))
+	assert.False(m.Matches(&pb.CommitRequest{Writes: []*pb.Write{del("a")}}))
+	assert.Equal("batch of writes among\ndelete C/a\ndelete C/b\ndelete C/c", m.String())
+
+	// test the expected writes are canonicalized like the request
+	update := func(mask ...string) *pb.Write {
+		return &pb.Write{
+			Operation:  &pb.Write_Update{Update: &pb.Document{Name: testDocs + "/C/a"}},
+			UpdateMask: &pb.DocumentMask{FieldPaths: mask},
+		}
+	}
+	req := &pb.BatchWriteRequest{Writes: []*pb.Write{update("y", "x")}}
+	assert.False(BatchOf(update("x", "y")).Matches(req))
+	assert.True(matchCanon(BatchOf(update("x", "y")), canonClone(req, canonAll), canonAll))
+}
+
+func TestCombinators(t *testing.T) {
+	assert := assert.New(t)
+
+	a := Equal(&pb.GetDocumentRequest{Name: "a"})
+	b := Equal(&pb.GetDocumentRequest{Name: "b"})
+	reqA := &pb.GetDocumentRequest{Name: "a"}
+
+	assert.True(And(a, Any()).Matches(reqA))
+	assert.False(And(a, b).Matches(reqA))
+	assert.True(And().Matches(reqA))
+	assert.True(Or(b, a).Matches(reqA))
+	assert.False(Or(b).Matches(reqA))
+	assert.False(Or().Matches(reqA))
+	assert.False(Not(a).Matches(reqA))
+	assert.True(Not(b).Matches(reqA))
+	assert.Equal("and(any request, not(any request))", And(Any(), Not(Any())).String())
+	assert.Equal("or(any request)", Or(Any()).String())
+}
+
+func TestAddRPCMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+
+	// Collection.Add generates a random document ID, so match everything else.
+	srv.AddRPCMatcher(
+		MethodCommit,
+		And(
+			Partial(&pb.CommitRequest{
+				Database: "projects/projectID/databases/(default)",
+				Writes: []*pb.Write{{
+					Operation: &pb.Write_Update{Update: &pb.Document{
+						Fields: map[string]*pb.Value{"a": {ValueType: &pb.Value_IntegerValue{IntegerValue: 1}}},
+					}},
+					CurrentDocument: &pb.Precondition{
+						ConditionType: &pb.Precondition_Exists{Exists: false},
+					},
+				}},
+			}),
+			DocumentNameMatches(`/documents/C/[^/]{20}$`),
+		),
+		&pb.CommitResponse{
+			WriteResults: []*pb.WriteResult{{UpdateTime: aTimestamp}},
+		},
+	)
+	_, _, err = client.Collection("C").Add(context.Background(), map[string]int{"a": 1})
+	assert.Nil(err)
+	assert.Nil(srv.CheckExpectations())
+
+	// test a method specific matcher is not offered other methods
+	srv.AddRPCMatcher(MethodRollback, Any(), &empty.Empty{})
+	_, err = srv.popRPC(context.Background(), &pb.CommitRequest{})
+	assert.NotNil(err)
+	assert.Contains(srv.expectations[0].String(), "Rollback expectation")
+}
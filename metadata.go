@@ -0,0 +1,94 @@
+package mockfs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	grpc "google.golang.org/grpc"
+	metadata "google.golang.org/grpc/metadata"
+)
+
+// WithMetadata requires the incoming gRPC metadata of matching requests to
+// include value under key, such as the "x-goog-request-params" routing
+// header. It may be called several times, and all pairs must be present.
+func (e *Expectation) WithMetadata(key, value string) *Expectation {
+	e.srv.mu.Lock()
+	defer e.srv.mu.Unlock()
+	if e.wantMD == nil {
+		e.wantMD = metadata.MD{}
+	}
+	e.wantMD.Append(key, value)
+	return e
+}
+
+// SetHeader sets the gRPC response header sent when the expectation is
+// matched.
+func (e *Expectation) SetHeader(md metadata.MD) *Expectation {
+	e.srv.mu.Lock()
+	defer e.srv.mu.Unlock()
+	e.replyHeader = metadata.Join(e.replyHeader, md)
+	return e
+}
+
+// SetTrailer sets the gRPC response trailer sent when the expectation is
+// matched.
+func (e *Expectation) SetTrailer(md metadata.MD) *Expectation {
+	e.srv.mu.Lock()
+	defer e.srv.mu.Unlock()
+	e.replyTrailer = metadata.Join(e.replyTrailer, md)
+	return e
+}
+
+// metadataMismatch describes the expected metadata missing from md, one line
+// per missing value, or returns "" if there is none. The caller must hold e.srv.mu.
+func (e *Expectation) metadataMismatch(md metadata.MD) string {
+	keys := make([]string, 0, len(e.wantMD))
+	for k := range e.wantMD {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var lines []string
+	for _, k := range keys {
+		got := md.Get(k)
+		for _, want := range e.wantMD[k] {
+			if !contains(got, want) {
+				lines = append(lines, fmt.Sprintf("metadata %s: got %q, want %q", k, got, want))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// sendMetadata sets the response header and trailer of e on the call of ctx.
+// Calls made directly on the MockServer, outside of a gRPC server, have no
+// response metadata, and the header and trailer are dropped.
+func (s *MockServer) sendMetadata(ctx context.Context, e *Expectation) error {
+	s.mu.Lock()
+	header, trailer := e.replyHeader, e.replyTrailer
+	s.mu.Unlock()
+	if grpc.ServerTransportStreamFromContext(ctx) == nil {
+		return nil
+	}
+	if len(header) > 0 {
+		if err := grpc.SetHeader(ctx, header); err != nil {
+			return s.unexpected(fmt.Sprintf("mockfs.popRPC: Cannot set header: %v", err))
+		}
+	}
+	if len(trailer) > 0 {
+		if err := grpc.SetTrailer(ctx, trailer); err != nil {
+			return s.unexpected(fmt.Sprintf("mockfs.popRPC: Cannot set trailer: %v", err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package mockfs
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	metadata "google.golang.org/grpc/metadata"
+	status "google.golang.org/grpc/status"
+)
+
+func TestWithMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ft := &fakeT{}
+	srv.SetReporter(ft)
+	ctx := context.Background()
+	commitResp := &pb.CommitResponse{
+		WriteResults: []*pb.WriteResult{{UpdateTime: aTimestamp}},
+		CommitTime:   aTimestamp,
+	}
+
+	srv.ExpectCommit(nil).Returns(commitResp).
+		WithMetadata("google-cloud-resource-prefix", testDB).
+		WithMetadata("X-Goog-Request-Params", "database=projects%2FprojectID%2Fdatabases%2F%28default%29")
+	_, err = client.Collection("C").Doc("a").Delete(ctx)
+	assert.Nil(err)
+	assert.Empty(ft.errors)
+
+	// test the metadata is recorded in the request history
+	calls := srv.CallsFor(MethodCommit)
+	assert.Len(calls, 1)
+	assert.Equal([]string{testDB}, calls[0].Metadata.Get("google-cloud-resource-prefix"))
+
+	srv.ExpectCommit(nil).Returns(commitResp).WithMetadata("google-cloud-resource-prefix", "projects/p/databases/other")
+	_, err = client.Collection("C").Doc("a").Delete(ctx)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	if assert.Len(ft.errors, 1) {
+		assert.Contains(ft.errors[0], `metadata google-cloud-resource-prefix: got ["`+testDB+`"], want "projects/p/databases/other"`)
+	}
+}
+
+func TestResponseMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	conn, err := grpc.Dial(srv.Addr, grpc.WithInsecure(), grpc.WithBlock())
+	assert.Nil(err)
+	defer conn.Close()
+	client := pb.NewFirestoreClient(conn)
+	ctx := context.Background()
+
+	srv.ExpectGetDocument(nil).Returns(&pb.Document{Name: "a"}).
+		SetHeader(metadata.Pairs("h", "1")).
+		SetTrailer(metadata.Pairs("t", "2"))
+	var header, trailer metadata.MD
+	_, err = client.GetDocument(ctx, &pb.GetDocumentRequest{Name: "a"}, grpc.Header(&header), grpc.Trailer(&trailer))
+	assert.Nil(err)
+	assert.Equal([]string{"1"}, header.Get("h"))
+	assert.Equal([]string{"2"}, trailer.Get("t"))
+
+	srv.ExpectRunQuery(nil).RespondsWithContext(func(ctx context.Context, req *pb.RunQueryRequest) ([]*pb.RunQueryResponse, error) {
+		if err := grpc.SetHeader(ctx, metadata.Pairs("parent", req.Parent)); err != nil {
+			return nil, err
+		}
+		return []*pb.RunQueryResponse{{ReadTime: aTimestamp}}, nil
+	})
+	stream, err := client.RunQuery(ctx, &pb.RunQueryRequest{Parent: "p"})
+	assert.Nil(err)
+	header, err = stream.Header()
+	assert.Nil(err)
+	assert.Equal([]string{"p"}, header.Get("parent"))
+	_, err = stream.Recv()
+	assert.Nil(err)
+	_, err = stream.Recv()
+	assert.Equal(io.EOF, err)
+
+	// test response metadata is dropped on direct calls
+	srv.ExpectGetDocument(nil).Returns(&pb.Document{}).SetHeader(metadata.Pairs("h", "1"))
+	_, err = srv.GetDocument(ctx, &pb.GetDocumentRequest{})
+	assert.Nil(err)
+}
@@ -0,0 +1,101 @@
+package mockfs
+
+import (
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Names of the Firestore RPC methods. Expectations are queued per method, so
+// ordering is only enforced between requests to the same method.
+const (
+	MethodGetDocument       = "GetDocument"
+	MethodCommit            = "Commit"
+	MethodBatchGetDocuments = "BatchGetDocuments"
+	MethodRunQuery          = "RunQuery"
+	MethodBeginTransaction  = "BeginTransaction"
+	MethodRollback          = "Rollback"
+	MethodListen            = "Listen"
+
+	MethodListDocuments       = "ListDocuments"
+	MethodUpdateDocument      = "UpdateDocument"
+	MethodDeleteDocument      = "DeleteDocument"
+	MethodCreateDocument      = "CreateDocument"
+	MethodRunAggregationQuery = "RunAggregationQuery"
+	MethodPartitionQuery      = "PartitionQuery"
+	MethodWrite               = "Write"
+	MethodListCollectionIds   = "ListCollectionIds"
+	MethodBatchWrite          = "BatchWrite"
+)
+
+// methodOf returns the name of the RPC method that accepts req, or the empty
+// string if req is nil or not a known request type.
+func methodOf(req proto.Message) string {
+	switch req.(type) {
+	case *pb.GetDocumentRequest:
+		return MethodGetDocument
+	case *pb.CommitRequest:
+		return MethodCommit
+	case *pb.BatchGetDocumentsRequest:
+		return MethodBatchGetDocuments
+	case *pb.RunQueryRequest:
+		return MethodRunQuery
+	case *pb.BeginTransactionRequest:
+		return MethodBeginTransaction
+	case *pb.RollbackRequest:
+		return MethodRollback
+	case *pb.ListenRequest:
+		return MethodListen
+	case *pb.ListDocumentsRequest:
+		return MethodListDocuments
+	case *pb.UpdateDocumentRequest:
+		return MethodUpdateDocument
+	case *pb.DeleteDocumentRequest:
+		return MethodDeleteDocument
+	case *pb.CreateDocumentRequest:
+		return MethodCreateDocument
+	case *pb.RunAggregationQueryRequest:
+		return MethodRunAggregationQuery
+	case *pb.PartitionQueryRequest:
+		return MethodPartitionQuery
+	case *pb.WriteRequest:
+		return MethodWrite
+	case *pb.ListCollectionIdsRequest:
+		return MethodListCollectionIds
+	case *pb.BatchWriteRequest:
+		return MethodBatchWrite
+	default:
+		return ""
+	}
+}
+
+// An rpcType describes the request and response types of a method scripted
+// by the MockServer. For streaming methods, resp is the type of the messages
+// sent on the stream.
+type rpcType struct {
+	req    reflect.Type
+	resp   reflect.Type
+	stream bool
+}
+
+var rpcTypes = map[string]rpcType{
+	MethodGetDocument:       {typeOf[*pb.GetDocumentRequest](), typeOf[*pb.Document](), false},
+	MethodCommit:            {typeOf[*pb.CommitRequest](), typeOf[*pb.CommitResponse](), false},
+	MethodBatchGetDocuments: {typeOf[*pb.BatchGetDocumentsRequest](), typeOf[*pb.BatchGetDocumentsResponse](), true},
+	MethodRunQuery:          {typeOf[*pb.RunQueryRequest](), typeOf[*pb.RunQueryResponse](), true},
+	MethodBeginTransaction:  {typeOf[*pb.BeginTransactionRequest](), typeOf[*pb.BeginTransactionResponse](), false},
+	MethodRollback:          {typeOf[*pb.RollbackRequest](), typeOf[*empty.Empty](), false},
+	MethodListen:            {typeOf[*pb.ListenRequest](), typeOf[*pb.ListenResponse](), true},
+
+	MethodListDocuments:       {typeOf[*pb.ListDocumentsRequest](), typeOf[*pb.ListDocumentsResponse](), false},
+	MethodRunAggregationQuery: {typeOf[*pb.RunAggregationQueryRequest](), typeOf[*pb.RunAggregationQueryResponse](), true},
+	MethodPartitionQuery:      {typeOf[*pb.PartitionQueryRequest](), typeOf[*pb.PartitionQueryResponse](), false},
+	MethodListCollectionIds:   {typeOf[*pb.ListCollectionIdsRequest](), typeOf[*pb.ListCollectionIdsResponse](), false},
+	MethodBatchWrite:          {typeOf[*pb.BatchWriteRequest](), typeOf[*pb.BatchWriteResponse](), false},
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
@@ -0,0 +1,38 @@
+package mockfs
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	assert "github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+)
+
+func TestMethodOf(t *testing.T) {
+	tests := []struct {
+		req  proto.Message
+		want string
+	}{
+		{&pb.GetDocumentRequest{}, MethodGetDocument},
+		{&pb.CommitRequest{}, MethodCommit},
+		{&pb.BatchGetDocumentsRequest{}, MethodBatchGetDocuments},
+		{&pb.RunQueryRequest{}, MethodRunQuery},
+		{&pb.BeginTransactionRequest{}, MethodBeginTransaction},
+		{&pb.RollbackRequest{}, MethodRollback},
+		{&pb.ListenRequest{}, MethodListen},
+		{&pb.ListDocumentsRequest{}, MethodListDocuments},
+		{&pb.UpdateDocumentRequest{}, MethodUpdateDocument},
+		{&pb.DeleteDocumentRequest{}, MethodDeleteDocument},
+		{&pb.CreateDocumentRequest{}, MethodCreateDocument},
+		{&pb.RunAggregationQueryRequest{}, MethodRunAggregationQuery},
+		{&pb.PartitionQueryRequest{}, MethodPartitionQuery},
+		{&pb.WriteRequest{}, MethodWrite},
+		{&pb.ListCollectionIdsRequest{}, MethodListCollectionIds},
+		{&pb.BatchWriteRequest{}, MethodBatchWrite},
+		{&pb.Document{}, ""},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, methodOf(test.req))
+	}
+}
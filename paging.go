@@ -0,0 +1,92 @@
+package mockfs
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// A PagedCall builds the expectations for a unary RPC whose results are split
+// into pages linked by page tokens. It is created by one of the MockServer
+// Expect methods.
+type PagedCall[Req, Resp proto.Message] struct {
+	*UnaryCall[Req, Resp]
+}
+
+// Matching replaces the request check with m.
+func (c PagedCall[Req, Resp]) Matching(m Matcher) PagedCall[Req, Resp] {
+	c.UnaryCall.Matching(m)
+	return c
+}
+
+// ReturnsPages adds one expectation per page, answering the requests of a
+// paged listing in order. Each page but the last links to the next one by its
+// next_page_token, set to "page-2", "page-3" and so on unless the page already
+// has one, and the expectation of the following page requires the request to
+// send that token back. The first request must not carry a page token.
+func (c PagedCall[Req, Resp]) ReturnsPages(pages ...Resp) []*Expectation {
+	es := make([]*Expectation, len(pages))
+	token := ""
+	for i, page := range pages {
+		resp := proto.Clone(page)
+		if i < len(pages)-1 && stringField(resp, "next_page_token") == "" {
+			setStringField(resp, "next_page_token", fmt.Sprintf("page-%d", i+2))
+		}
+		m, wantReq := c.pageMatcher(token)
+		es[i] = c.srv.add(newExpectation(c.method, m, wantReq, resp))
+		token = stringField(resp, "next_page_token")
+	}
+	return es
+}
+
+// pageMatcher returns the matcher for the request of the page reached with
+// token.
+func (c PagedCall[Req, Resp]) pageMatcher(token string) (Matcher, proto.Message) {
+	if c.wantReq != nil {
+		req := proto.Clone(c.wantReq)
+		setStringField(req, "page_token", token)
+		return Equal(req), req
+	}
+	return And(c.matcher, pageTokenMatcher{token}), nil
+}
+
+// PartitionCursors returns the partition cursors of a PartitionQuery
+// response splitting a query at the documents names, which are full
+// document resource names. Each cursor holds the reference value of one
+// document.
+func PartitionCursors(names ...string) []*pb.Cursor {
+	cursors := make([]*pb.Cursor, len(names))
+	for i, name := range names {
+		cursors[i] = &pb.Cursor{Values: []*pb.Value{
+			{ValueType: &pb.Value_ReferenceValue{ReferenceValue: name}},
+		}}
+	}
+	return cursors
+}
+
+type pageTokenMatcher struct {
+	token string
+}
+
+func (m pageTokenMatcher) Matches(req proto.Message) bool {
+	fd := proto.MessageReflect(req).Descriptor().Fields().ByName("page_token")
+	return fd != nil && stringField(req, "page_token") == m.token
+}
+
+func (m pageTokenMatcher) String() string {
+	return fmt.Sprintf("page token %q", m.token)
+}
+
+// stringField returns the value of the string field name of m.
+func stringField(m proto.Message, name protoreflect.Name) string {
+	msg := proto.MessageReflect(m)
+	return msg.Get(msg.Descriptor().Fields().ByName(name)).String()
+}
+
+// setStringField sets the string field name of m to v.
+func setStringField(m proto.Message, name protoreflect.Name, v string) {
+	msg := proto.MessageReflect(m)
+	msg.Set(msg.Descriptor().Fields().ByName(name), protoreflect.ValueOfString(v))
+}
@@ -0,0 +1,143 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	iterator "google.golang.org/api/iterator"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+)
+
+func TestReturnsPages(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	page := func(names ...string) *pb.ListDocumentsResponse {
+		resp := &pb.ListDocumentsResponse{}
+		for _, name := range names {
+			resp.Documents = append(resp.Documents, &pb.Document{Name: testDocs + "/C/" + name})
+		}
+		return resp
+	}
+	first := page("a", "b")
+	es := srv.ExpectListDocuments(&pb.ListDocumentsRequest{
+		Parent:       testDocs,
+		CollectionId: "C",
+		ShowMissing:  true,
+		Mask:         &pb.DocumentMask{},
+	}).ReturnsPages(first, page("c"), page("d"))
+	assert.Len(es, 3)
+	assert.Empty(first.NextPageToken)
+
+	refs, err := client.Collection("C").DocumentRefs(ctx).GetAll()
+	assert.Nil(err)
+	var ids []string
+	for _, ref := range refs {
+		ids = append(ids, ref.ID)
+	}
+	assert.Equal([]string{"a", "b", "c", "d"}, ids)
+	var tokens []string
+	for _, c := range srv.CallsFor(MethodListDocuments) {
+		tokens = append(tokens, c.Request.(*pb.ListDocumentsRequest).PageToken)
+	}
+	assert.Equal([]string{"", "page-2", "page-3"}, tokens)
+	assert.True(srv.Verify(t))
+
+	// test a request with the wrong page token is reported
+	ft := &fakeT{}
+	srv.SetReporter(ft)
+	srv.ExpectListDocuments(nil).ReturnsPages(&pb.ListDocumentsResponse{NextPageToken: "next"}, page("a"))
+	_, err = srv.ListDocuments(ctx, &pb.ListDocumentsRequest{})
+	assert.Nil(err)
+	_, err = srv.ListDocuments(ctx, &pb.ListDocumentsRequest{PageToken: "page-2"})
+	assert.NotNil(err)
+	if assert.Len(ft.errors, 1) {
+		assert.Contains(ft.errors[0], `page token "next"`)
+	}
+	_, err = srv.ListDocuments(ctx, &pb.ListDocumentsRequest{PageToken: "next"})
+	assert.Nil(err)
+
+	// test the pages can be matched with a matcher
+	srv.ExpectListDocuments(nil).
+		Matching(Partial(&pb.ListDocumentsRequest{CollectionId: "D"})).
+		ReturnsPages(page("a"), page())
+	it := client.Collection("D").DocumentRefs(ctx)
+	_, err = it.Next()
+	assert.Nil(err)
+	_, err = it.Next()
+	assert.Equal(iterator.Done, err)
+	assert.Len(ft.errors, 1)
+	assert.Nil(srv.CheckExpectations())
+}
+
+func TestListCollectionIdsPages(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	srv.ExpectListCollectionIds(&pb.ListCollectionIdsRequest{Parent: testDocs}).ReturnsPages(
+		&pb.ListCollectionIdsResponse{CollectionIds: []string{"A", "B"}},
+		&pb.ListCollectionIdsResponse{CollectionIds: []string{"C"}},
+	)
+	srv.ExpectListCollectionIds(&pb.ListCollectionIdsRequest{Parent: testDocs + "/A/a"}).ReturnsPages(
+		&pb.ListCollectionIdsResponse{CollectionIds: []string{"S"}},
+	)
+
+	cols, err := client.Collections(ctx).GetAll()
+	assert.Nil(err)
+	var ids []string
+	for _, col := range cols {
+		ids = append(ids, col.ID)
+	}
+	assert.Equal([]string{"A", "B", "C"}, ids)
+
+	subs, err := client.Doc("A/a").Collections(ctx).GetAll()
+	assert.Nil(err)
+	if assert.Len(subs, 1) {
+		assert.Equal("A/a/S", subs[0].Path[len(testDocs)+1:])
+	}
+	assert.True(srv.Verify(t))
+}
+
+func TestPartitionQueryPages(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	cursors := PartitionCursors(testDocs+"/C/m", testDocs+"/C/d")
+	if assert.Len(cursors, 2) {
+		assert.Equal(testDocs+"/C/m", cursors[0].Values[0].GetReferenceValue())
+	}
+
+	srv.ExpectPartitionQuery(nil).
+		Matching(Partial(&pb.PartitionQueryRequest{Parent: testDocs, PartitionCount: 4})).
+		ReturnsPages(
+			&pb.PartitionQueryResponse{Partitions: cursors},
+			&pb.PartitionQueryResponse{Partitions: PartitionCursors(testDocs + "/C/t")},
+		)
+	queries, err := client.CollectionGroup("C").GetPartitionedQueries(ctx, 4)
+	assert.Nil(err)
+	assert.Len(queries, 4)
+	assert.True(srv.Verify(t))
+
+	// test the partitions split the collection group at the cursors, sorted
+	for _, q := range queries[1:] {
+		srv.ExpectRunQuery(nil).Streams(&pb.RunQueryResponse{ReadTime: aTimestamp})
+		_, err := q.Documents(ctx).GetAll()
+		assert.Nil(err)
+	}
+	var starts []string
+	for _, c := range srv.CallsFor(MethodRunQuery) {
+		q := c.Request.(*pb.RunQueryRequest).GetStructuredQuery()
+		starts = append(starts, q.StartAt.Values[0].GetReferenceValue())
+	}
+	assert.Equal([]string{testDocs + "/C/d", testDocs + "/C/m", testDocs + "/C/t"}, starts)
+}
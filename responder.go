@@ -0,0 +1,54 @@
+package mockfs
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+)
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	messageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+)
+
+// respond computes the response to gotReq, received with ctx, from a scripted
+// response. Responder functions are called with the request, preceded by ctx
+// if they take a context.Context; any other value is returned as is, or as the
+// error if it is one.
+func (s *MockServer) respond(ctx context.Context, resp interface{}, gotReq proto.Message) (interface{}, error) {
+	fn := reflect.ValueOf(resp)
+	if fn.Kind() != reflect.Func {
+		return response(resp)
+	}
+	ft := fn.Type()
+	var args []reflect.Value
+	if ft.NumIn() == 2 && ft.In(0) == contextType {
+		args = append(args, reflect.ValueOf(&ctx).Elem())
+	}
+	if gotReq == nil || ft.NumIn() != len(args)+1 || !reflect.TypeOf(gotReq).AssignableTo(ft.In(len(args))) ||
+		ft.NumOut() != 2 || ft.Out(1) != errorType {
+		return nil, s.unexpected(fmt.Sprintf("mockfs.popRPC: Bad responder type for %T: %T", gotReq, resp))
+	}
+	out := fn.Call(append(args, reflect.ValueOf(gotReq)))
+	if err, _ := out[1].Interface().(error); err != nil {
+		return nil, err
+	}
+	return streamValues(out[0]), nil
+}
+
+// streamValues converts a typed slice of stream messages, such as
+// []*pb.RunQueryResponse, into the []interface{} form used by the streaming
+// handlers. Other values are returned unchanged.
+func streamValues(v reflect.Value) interface{} {
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Interface {
+		return v.Interface()
+	}
+	values := make([]interface{}, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface()
+	}
+	return values
+}
@@ -0,0 +1,117 @@
+package mockfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	errors "github.com/weathersource/go-errors"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+func TestResponder(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ctx := context.Background()
+
+	// echo the generated document ID back on the following Get
+	var added string
+	srv.AddRPC(nil, func(req *pb.CommitRequest) (*pb.CommitResponse, error) {
+		added = req.Writes[0].GetUpdate().GetName()
+		return &pb.CommitResponse{
+			WriteResults: []*pb.WriteResult{{UpdateTime: aTimestamp}},
+			CommitTime:   aTimestamp,
+		}, nil
+	})
+	srv.AddRPC(nil, func(req *pb.BatchGetDocumentsRequest) ([]*pb.BatchGetDocumentsResponse, error) {
+		var resps []*pb.BatchGetDocumentsResponse
+		for _, name := range req.Documents {
+			resps = append(resps, &pb.BatchGetDocumentsResponse{
+				Result: &pb.BatchGetDocumentsResponse_Found{Found: &pb.Document{
+					Name:       name,
+					CreateTime: aTimestamp,
+					UpdateTime: aTimestamp,
+				}},
+				ReadTime: aTimestamp2,
+			})
+		}
+		return resps, nil
+	})
+	ref, _, err := client.Collection("C").Add(ctx, map[string]int{"a": 1})
+	assert.Nil(err)
+	assert.True(strings.HasSuffix(added, "/documents/C/"+ref.ID))
+	snap, err := ref.Get(ctx)
+	assert.Nil(err)
+	if assert.NotNil(snap) {
+		assert.Equal(ref.ID, snap.Ref.ID)
+		assert.True(snap.Exists())
+	}
+
+	// test responder errors
+	srv.AddRPC(nil, func(req *pb.BatchGetDocumentsRequest) ([]interface{}, error) {
+		return nil, errors.NewPermissionDeniedError("")
+	})
+	_, err = ref.Get(ctx)
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+
+	// test responders are called on every match
+	n := 0
+	srv.AddRPC(nil, func(req *pb.GetDocumentRequest) (*pb.Document, error) {
+		n++
+		return &pb.Document{Name: req.Name}, nil
+	}).Times(2)
+	for i := 0; i < 2; i++ {
+		resp, err := srv.GetDocument(ctx, &pb.GetDocumentRequest{Name: "a"})
+		assert.Nil(err)
+		assert.Equal("a", resp.Name)
+	}
+	assert.Equal(2, n)
+}
+
+func TestResponderBadType(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+	ft := &fakeT{}
+	srv.SetReporter(ft)
+
+	tests := []interface{}{
+		func(req *pb.CommitRequest) (*pb.Document, error) { return nil, nil },
+		func() (*pb.Document, error) { return nil, nil },
+		func(req *pb.GetDocumentRequest) *pb.Document { return nil },
+		func(req *pb.GetDocumentRequest) (*pb.Document, bool) { return nil, false },
+	}
+	for _, test := range tests {
+		srv.AddRPC(nil, test)
+		_, err = srv.GetDocument(context.Background(), &pb.GetDocumentRequest{})
+		assert.Equal(codes.FailedPrecondition, status.Code(err))
+	}
+	if assert.Len(ft.errors, len(tests)) {
+		assert.Contains(ft.errors[0], "mockfs.popRPC: Bad responder type for *firestorepb.GetDocumentRequest")
+	}
+}
+
+func TestStreamValues(t *testing.T) {
+	assert := assert.New(t)
+
+	a := &pb.RunQueryResponse{}
+	values, err := (&MockServer{}).respond(context.Background(), func(*pb.RunQueryRequest) ([]*pb.RunQueryResponse, error) {
+		return []*pb.RunQueryResponse{a}, nil
+	}, &pb.RunQueryRequest{})
+	assert.Nil(err)
+	assert.Equal([]interface{}{a}, values)
+
+	values, err = (&MockServer{}).respond(context.Background(), func(*pb.RunQueryRequest) ([]interface{}, error) {
+		return []interface{}{a, errors.NewAbortedError("")}, nil
+	}, &pb.RunQueryRequest{})
+	assert.Nil(err)
+	if assert.Len(values, 2) {
+		assert.Equal(codes.Aborted, status.Code(values.([]interface{})[1].(error)))
+	}
+}
@@ -20,27 +20,38 @@ package mockfs
 // A simple mock server.
 
 import (
+	"context"
 	"fmt"
-	"sort"
+	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	errors "github.com/weathersource/go-errors"
 	gsrv "github.com/weathersource/go-gsrv"
 	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	metadata "google.golang.org/grpc/metadata"
+	status "google.golang.org/grpc/status"
 )
 
 // MockServer mocks the pb.FirestoreServer interface
 // (https://godoc.org/google.golang.org/genproto/googleapis/firestore/v1beta1#FirestoreServer)
+//
+// A MockServer is safe for concurrent use. gRPC runs each handler on its own
+// goroutine, so expectations may be added and consumed from any goroutine.
 type MockServer struct {
-	pb.FirestoreServer
-	Addr     string
-	reqItems []reqItem
-	resps    []interface{}
-}
+	Addr string
 
-type reqItem struct {
-	wantReq proto.Message
-	adjust  func(gotReq proto.Message)
+	mu           sync.Mutex // guards the fields below
+	expectations []*Expectation
+	unordered    bool
+	reporter     TestingT
+	reporterID   int // incremented by SetReporter, to tell reporters apart
+	calls        []*Call
+	canonRules   []*canonRule
+	vars         map[string]string // values captured from requests
+	methodDelays map[string]time.Duration
+	faults       []*fault
 }
 
 func newServer() (*MockServer, error) {
@@ -48,80 +59,358 @@ func newServer() (*MockServer, error) {
 	if err != nil {
 		return nil, err
 	}
-	mock := &MockServer{Addr: srv.Addr}
+	mock := &MockServer{Addr: srv.Addr, canonRules: defaultCanonRules()}
 	pb.RegisterFirestoreServer(srv.Gsrv, mock)
 	srv.Start()
 	return mock, nil
 }
 
-// Reset returns the MockServer to an empty state.
+// Reset returns the MockServer to an empty state, discarding all
+// expectations, the recorded request history, captured values and injected
+// faults.
 func (s *MockServer) Reset() {
-	s.reqItems = nil
-	s.resps = nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expectations = nil
+	s.calls = nil
+	s.vars = nil
+	s.faults = nil
+}
+
+// SetUnordered switches the server between ordered and unordered matching.
+// By default expectations are consumed strictly in the order they were added.
+// When unordered is true, an incoming request is compared with every pending
+// expectation and the first one that matches is consumed, which suits code
+// that issues RPCs concurrently or in map-iteration order.
+func (s *MockServer) SetUnordered(unordered bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unordered = unordered
+}
+
+// SetReporter sets the TestingT, usually the *testing.T that owns the server,
+// to which unexpected calls are reported. Unexpected calls, such as requests
+// that match no expectation, are always returned to the client as a
+// FailedPrecondition status; with a reporter they also fail the test. Passing
+// nil removes the reporter.
+//
+// If t is a CleanupT, such as *testing.T, the reporter is removed when t
+// completes, so calls that arrive later, for example from a background
+// goroutine, do not fail a finished test.
+func (s *MockServer) SetReporter(t TestingT) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reporter = t
+	s.reporterID++
+	if ct, ok := t.(CleanupT); ok {
+		id := s.reporterID
+		ct.Cleanup(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.reporterID == id {
+				s.reporter = nil
+			}
+		})
+	}
 }
 
 // AddRPC adds a (request, response) pair to the server's list of expected
 // interactions. The server will compare the incoming request with wantReq
-// using proto.Equal. The response can be a message or an error.
+// using proto.Equal, after both have been rewritten into canonical form: Var
+// placeholders in wantReq are replaced by the values captured so far, and the
+// enabled canonicalization rules, including those added by IgnoreTransaction,
+// IgnoreReadTime and IgnoreAutoID, are applied. See AddCanonRule and
+// SetCanonRuleEnabled. The response can be a message or an error.
+//
+// The response can also be a responder function that receives the actual
+// typed request and returns the response or an error, for example
+// func(*pb.CommitRequest) (*pb.CommitResponse, error). It is called each time
+// the expectation is matched. A responder for a streaming method returns the
+// sequence of messages to send, either as a []interface{} or as a slice of the
+// method's response type. A responder may also take a context.Context before
+// the request, for example to set response metadata with grpc.SetHeader.
+//
+// The returned Expectation is matched exactly once unless its call count is
+// changed with Times, AtLeast, AtMost or AnyTimes.
 //
 // For the Listen RPC, resp should be a []interface{}, where each element
 // is either ListenResponse or an error.
 //
-// Passing nil for wantReq disables the request check.
-func (s *MockServer) AddRPC(wantReq proto.Message, resp interface{}) {
-	s.AddRPCAdjust(wantReq, resp, nil)
+// Expectations are queued per RPC method, as determined by the type of wantReq,
+// so requests to different methods may interleave freely. Passing nil for
+// wantReq disables the request check; such an expectation is queued for every
+// method.
+//
+// The response is checked against the method's response type when the
+// expectation is added. A mismatch is reported to the server's reporter, or
+// panics if there is none, and the expectation is not queued. The response
+// of an expectation with a nil wantReq is not validated, as it may answer any
+// method; a wrong type is only reported when a request is answered with it.
+func (s *MockServer) AddRPC(wantReq proto.Message, resp interface{}) *Expectation {
+	return s.AddRPCAdjust(wantReq, resp, nil)
 }
 
 // AddRPCAdjust is like AddRPC, but accepts a function that can be used
 // to tweak the requests before comparison, for example to adjust for
 // randomness.
-func (s *MockServer) AddRPCAdjust(wantReq proto.Message, resp interface{}, adjust func(gotReq proto.Message)) {
-	s.reqItems = append(s.reqItems, reqItem{wantReq, adjust})
-	s.resps = append(s.resps, resp)
-}
-
-// popRPC compares the request with the next expected (request, response) pair.
-// It returns the response, or an error if the request doesn't match what
-// was expected or there are no expected rpcs.
-func (s *MockServer) popRPC(gotReq proto.Message) (interface{}, error) {
-	if len(s.reqItems) == 0 || len(s.resps) == 0 {
-		panic("mockfs.popRPC: Out of RPCs.")
-	}
-	ri := s.reqItems[0]
-	resp := s.resps[0]
-	s.reqItems = s.reqItems[1:]
-	s.resps = s.resps[1:]
-	if ri.wantReq != nil {
-		if ri.adjust != nil {
-			ri.adjust(gotReq)
+func (s *MockServer) AddRPCAdjust(wantReq proto.Message, resp interface{}, adjust func(gotReq proto.Message)) *Expectation {
+	m, wantReq := requestMatcher(wantReq)
+	e := newExpectation(methodOf(wantReq), m, wantReq, resp)
+	e.adjust = adjust
+	return s.add(e)
+}
+
+// AddRPCMatcher adds an expectation for the named RPC method (one of the
+// Method constants) that accepts any request satisfying m. An empty method
+// queues the expectation for every method. The response is interpreted as
+// for AddRPC.
+func (s *MockServer) AddRPCMatcher(method string, m Matcher, resp interface{}) *Expectation {
+	return s.add(newExpectation(method, m, nil, resp))
+}
+
+// add queues e. If e is for a single method, its response is checked first,
+// and an invalid one is reported as a misuse and not queued. Otherwise only
+// typed slices of stream messages are converted.
+func (s *MockServer) add(e *Expectation) *Expectation {
+	e.srv = s
+	if e.method == "" {
+		e.resp = anyMethodResponse(e.resp)
+	} else {
+		resp, msg := checkResponse(e.method, e.resp)
+		if msg != "" {
+			s.misuse("mockfs.AddRPC: " + msg)
+			return e
+		}
+		e.resp = resp
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expectations = append(s.expectations, e)
+	return e
+}
+
+// CheckExpectations returns an error listing every expectation whose call
+// count constraint has not been met, or nil if all of them have.
+func (s *MockServer) CheckExpectations() error {
+	if msg := s.unmetMessage(); msg != "" {
+		return errors.NewInternalError("mockfs.CheckExpectations: " + msg)
+	}
+	return nil
+}
+
+// unmetMessage describes every expectation whose call count constraint has
+// not been met, or returns the empty string if all of them have.
+func (s *MockServer) unmetMessage() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var unmet []*Expectation
+	for _, e := range s.expectations {
+		if !e.satisfied() {
+			unmet = append(unmet, e)
+		}
+	}
+	if len(unmet) == 0 {
+		return ""
+	}
+	msg := fmt.Sprintf("%d expectations not met:", len(unmet))
+	for i, e := range unmet {
+		msg += fmt.Sprintf("\n[%d] %s", i, e)
+	}
+	return msg
+}
+
+// popRPC compares the request with the next expected (request, response) pair
+// queued for its method. It returns the response, or an error if the request
+// doesn't match what was expected or there are no expected rpcs. Such
+// unexpected calls are also reported to the server's reporter. Injected faults
+// that fail calls are applied before the request is matched, and those that
+// cut streams only once a scripted stream has been matched.
+func (s *MockServer) popRPC(ctx context.Context, gotReq proto.Message) (interface{}, error) {
+	if err := s.injectFaults(methodOf(gotReq)); err != nil {
+		return nil, err
+	}
+	if err := sleep(ctx, s.methodDelay(methodOf(gotReq))); err != nil {
+		return nil, err
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	e, msg := s.consume(gotReq, md)
+	if msg != "" {
+		return nil, s.unexpected(msg)
+	}
+	delay, streamDelay := s.delays(e)
+	if err := sleep(ctx, delay); err != nil {
+		return nil, err
+	}
+	if err := s.sendMetadata(ctx, e); err != nil {
+		return nil, err
+	}
+	resp, err := s.respond(ctx, e.resp, gotReq)
+	if err != nil {
+		return nil, err
+	}
+	resp = s.cutStream(methodOf(gotReq), s.bindResponse(resp))
+	return withPauses(resp, streamDelay), nil
+}
+
+// consume finds and consumes the expectation matching gotReq, received with
+// incoming metadata md. If there is none, it returns a message describing the
+// unexpected call instead.
+//
+// A request that does not match is not counted as a call, so the expectation
+// it was compared with stays pending and is reported by CheckExpectations. An
+// expectation whose minimum call count has already been met does not block
+// the queue: if the request does not match it, the following expectations are
+// tried. In unordered mode the request is compared with every pending
+// expectation for its method instead, and the first one that matches is
+// consumed. Requests are compared in their canonical form; see AddCanonRule.
+func (s *MockServer) consume(gotReq proto.Message, md metadata.MD) (*Expectation, string) {
+	for {
+		e, msg, retry := s.tryConsume(gotReq, md)
+		if !retry {
+			return e, msg
 		}
+	}
+}
+
+// tryConsume makes one attempt at consume. Matchers, adjust functions and
+// canonicalization rules are user code, which may call back into the server,
+// so they run without s.mu on a snapshot of the pending expectations. If the
+// matching expectation is used up by a concurrent call in the meantime,
+// tryConsume asks to be retried.
+func (s *MockServer) tryConsume(gotReq proto.Message, md metadata.MD) (e *Expectation, msg string, retry bool) {
+	method := methodOf(gotReq)
+	s.mu.Lock()
+	var pending []*Expectation
+	for _, e := range s.expectations {
+		if e.method == "" || e.method == method {
+			pending = append(pending, e)
+		}
+	}
+	unordered := s.unordered
+	canonReq, canonWant := s.normalizers()
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return nil, fmt.Sprintf("mockfs.popRPC: Out of RPCs for %s\ngot:  %T\n%s",
+			method, gotReq, proto.MarshalTextString(gotReq)), false
+	}
 
-		// Sort FieldTransforms by FieldPath, since slice order is undefined and proto.Equal
-		// is strict about order.
-		switch gotReqTyped := gotReq.(type) {
-		case *pb.CommitRequest:
-			for _, w := range gotReqTyped.Writes {
-				switch opTyped := w.Operation.(type) {
-				case *pb.Write_Transform:
-					sort.Sort(byFieldPath(opTyped.Transform.FieldTransforms))
-				}
+	for _, e := range pending {
+		matched := e.matches(gotReq, canonReq, canonWant)
+		s.mu.Lock()
+		if matched && e.metadataMismatch(md) == "" {
+			if !s.queued(e) {
+				s.mu.Unlock()
+				return nil, "", true
+			}
+			s.call(e)
+			msg := s.capture(e, gotReq)
+			s.mu.Unlock()
+			if msg != "" {
+				return nil, msg, false
 			}
+			return e, "", false
+		}
+		blocking := !unordered && !e.satisfied()
+		s.mu.Unlock()
+		if blocking {
+			return nil, fmt.Sprintf("mockfs.popRPC: Bad request for %s\n%s", method, s.mismatch(gotReq, md, e, canonReq, canonWant)), false
 		}
+	}
+	msg = fmt.Sprintf("mockfs.popRPC: Bad request for %s, none of %d pending expectations matched",
+		method, len(pending))
+	for i, e := range pending {
+		s.mu.Lock()
+		header := e.header()
+		s.mu.Unlock()
+		msg += fmt.Sprintf("\n[%d] %s\n%s", i, header, s.mismatch(gotReq, md, e, canonReq, canonWant))
+	}
+	return nil, msg, false
+}
 
-		if !proto.Equal(gotReq, ri.wantReq) {
-			return nil, errors.NewInternalError(fmt.Sprintf("mockfs.popRPC: Bad request\ngot:  %T\n%s\nwant: %T\n%s",
-				gotReq, proto.MarshalTextString(gotReq),
-				ri.wantReq, proto.MarshalTextString(ri.wantReq)))
+// queued reports whether e is still in the queue. The caller must hold s.mu.
+func (s *MockServer) queued(e *Expectation) bool {
+	for _, q := range s.expectations {
+		if q == e {
+			return true
 		}
 	}
+	return false
+}
+
+// mismatch explains why gotReq, received with metadata md, does not satisfy
+// e, comparing the requests in the forms given by canonReq and canonWant. The
+// caller must not hold s.mu.
+func (s *MockServer) mismatch(gotReq proto.Message, md metadata.MD, e *Expectation, canonReq, canonWant func(proto.Message)) string {
+	msg := describeMismatch(gotReq, e, canonReq, canonWant)
+	s.mu.Lock()
+	mm := e.metadataMismatch(md)
+	s.mu.Unlock()
+	if mm != "" {
+		msg += "\n" + mm
+	}
+	return msg
+}
+
+// unexpected reports msg to the server's reporter, if any, and returns it as
+// a FailedPrecondition error, which the Firestore client does not retry.
+func (s *MockServer) unexpected(msg string) error {
+	s.report(msg)
+	return errors.NewFailedPreconditionError(msg)
+}
+
+// unimplemented reports a call of a method the MockServer does not script and
+// returns an Unimplemented error naming the method and request.
+func (s *MockServer) unimplemented(gotReq proto.Message) error {
+	msg := fmt.Sprintf("mockfs: %s is not implemented by the mock\ngot:  %T\n%s",
+		methodOf(gotReq), gotReq, proto.MarshalTextString(gotReq))
+	s.report(msg)
+	return status.Error(codes.Unimplemented, msg)
+}
+
+// report passes msg to the server's reporter, if any. The lock is held while
+// reporting, so the reporter cannot be removed by its cleanup, and its test
+// cannot complete, in the middle of the call.
+func (s *MockServer) report(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reporter != nil {
+		s.reporter.Helper()
+		s.reporter.Errorf("%s", msg)
+	}
+}
+
+// badResponse reports a scripted response of the wrong type for method.
+func (s *MockServer) badResponse(method string, resp interface{}) error {
+	return s.unexpected(fmt.Sprintf("mockfs.%s: Bad response type: %T", method, resp))
+}
+
+// call records a request matching e, dropping e from the queue once it is
+// used up. The caller must hold s.mu.
+func (s *MockServer) call(e *Expectation) {
+	e.matched++
+	s.dropExhausted()
+}
+
+// dropExhausted removes every used up expectation from the queue. The caller
+// must hold s.mu.
+func (s *MockServer) dropExhausted() {
+	kept := s.expectations[:0]
+	for _, e := range s.expectations {
+		if !e.exhausted() {
+			kept = append(kept, e)
+		}
+	}
+	for i := len(kept); i < len(s.expectations); i++ {
+		s.expectations[i] = nil
+	}
+	s.expectations = kept
+}
+
+// response splits a scripted response into the value and error returned by
+// popRPC.
+func response(resp interface{}) (interface{}, error) {
 	if err, ok := resp.(error); ok {
 		return nil, err
 	}
 	return resp, nil
 }
-
-type byFieldPath []*pb.DocumentTransform_FieldTransform
-
-func (a byFieldPath) Len() int           { return len(a) }
-func (a byFieldPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byFieldPath) Less(i, j int) bool { return a[i].FieldPath < a[j].FieldPath }
@@ -1,13 +1,19 @@
 package mockfs
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/protobuf/proto"
-	tspb "google.golang.org/protobuf/types/known/timestamppb"
 	assert "github.com/stretchr/testify/assert"
+	errors "github.com/weathersource/go-errors"
 	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var (
@@ -42,8 +48,7 @@ func TestReset(t *testing.T) {
 		[]interface{}{},
 	)
 	srv.Reset()
-	assert.Nil(srv.reqItems)
-	assert.Nil(srv.resps)
+	assert.Nil(srv.expectations)
 }
 
 // modified from https://github.com/GoogleCloudPlatform/google-cloud-go/blob/master/firestore/docref_test.go
@@ -56,9 +61,9 @@ func TestAddRPC(t *testing.T) {
 		&pb.BatchGetDocumentsRequest{},
 		[]interface{}{},
 	)
-	assert.NotNil(srv.resps)
-	assert.NotNil(srv.reqItems[0].wantReq)
-	assert.Nil(srv.reqItems[0].adjust)
+	assert.NotNil(srv.expectations[0].resp)
+	assert.NotNil(srv.expectations[0].wantReq)
+	assert.Nil(srv.expectations[0].adjust)
 }
 
 // modified from https://github.com/GoogleCloudPlatform/google-cloud-go/blob/master/firestore/docref_test.go
@@ -72,9 +77,9 @@ func TestAddRPCAdjust(t *testing.T) {
 		[]interface{}{},
 		func(req proto.Message) {},
 	)
-	assert.NotNil(srv.resps)
-	assert.NotNil(srv.reqItems[0].wantReq)
-	assert.NotNil(srv.reqItems[0].adjust)
+	assert.NotNil(srv.expectations[0].resp)
+	assert.NotNil(srv.expectations[0].wantReq)
+	assert.NotNil(srv.expectations[0].adjust)
 }
 
 func TestPopRPC(t *testing.T) {
@@ -85,10 +90,8 @@ func TestPopRPC(t *testing.T) {
 	assert.Nil(err)
 
 	// test no RPCs
-	panicTest := func() {
-		srv.popRPC(nil)
-	}
-	assert.Panics(panicTest)
+	_, err = srv.popRPC(context.Background(), nil)
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
 
 	// test success adjust commit
 	wantReq := &pb.CommitRequest{
@@ -98,7 +101,7 @@ func TestPopRPC(t *testing.T) {
 				Operation: &pb.Write_Update{
 					Update: &pb.Document{
 						Name:   "projects/projectID/databases/(default)/documents/C/d",
-						Fields: map[string]*pb.Value{"a": {ValueType: &pb.Value_IntegerValue{int64(1)}}},
+						Fields: map[string]*pb.Value{"a": {ValueType: &pb.Value_IntegerValue{IntegerValue: int64(1)}}},
 					},
 				},
 			},
@@ -106,7 +109,7 @@ func TestPopRPC(t *testing.T) {
 	}
 	w := wantReq.Writes[0]
 	w.CurrentDocument = &pb.Precondition{
-		ConditionType: &pb.Precondition_Exists{false},
+		ConditionType: &pb.Precondition_Exists{Exists: false},
 	}
 	srv.AddRPCAdjust(
 		wantReq,
@@ -118,7 +121,7 @@ func TestPopRPC(t *testing.T) {
 			w.Operation.(*pb.Write_Update).Update.Name = gotReq.(*pb.CommitRequest).Writes[0].Operation.(*pb.Write_Update).Update.Name
 		},
 	)
-	resp, err := srv.popRPC(wantReq)
+	resp, err := srv.popRPC(context.Background(), wantReq)
 	assert.Nil(err)
 	assert.NotNil(resp)
 
@@ -128,7 +131,7 @@ func TestPopRPC(t *testing.T) {
 		Name:       path,
 		CreateTime: aTimestamp,
 		UpdateTime: aTimestamp,
-		Fields:     map[string]*pb.Value{"f": {ValueType: &pb.Value_IntegerValue{int64(1)}}},
+		Fields:     map[string]*pb.Value{"f": {ValueType: &pb.Value_IntegerValue{IntegerValue: int64(1)}}},
 	}
 	srv.AddRPC(
 		&pb.BatchGetDocumentsRequest{
@@ -136,12 +139,12 @@ func TestPopRPC(t *testing.T) {
 			Documents: []string{"projects/projectID/databases/(default)/documents/C/a"},
 		}, []interface{}{
 			&pb.BatchGetDocumentsResponse{
-				Result:   &pb.BatchGetDocumentsResponse_Found{pdoc},
+				Result:   &pb.BatchGetDocumentsResponse_Found{Found: pdoc},
 				ReadTime: aTimestamp2,
 			},
 		},
 	)
-	_, err = srv.popRPC(&pb.BatchGetDocumentsRequest{
+	_, err = srv.popRPC(context.Background(), &pb.BatchGetDocumentsRequest{
 		Database:  "projects/projectID/databases/(default)",
 		Documents: []string{"projects/projectID/databases/(default)/documents/C/b"},
 	})
@@ -179,3 +182,338 @@ func TestLess(t *testing.T) {
 	assert.True(t, bfp.Less(0, 1))
 	assert.False(t, bfp.Less(1, 0))
 }
+
+func TestConcurrentRPCs(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+
+	const n = 50
+	ctx := context.Background()
+	path := "projects/projectID/databases/(default)/documents/C/a"
+	pdoc := &pb.Document{
+		Name:       path,
+		CreateTime: aTimestamp,
+		UpdateTime: aTimestamp,
+		Fields:     map[string]*pb.Value{"f": {ValueType: &pb.Value_IntegerValue{IntegerValue: int64(1)}}},
+	}
+
+	// parallel gets, with expectations added from as many goroutines
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.AddRPC(nil, []interface{}{
+				&pb.BatchGetDocumentsResponse{
+					Result:   &pb.BatchGetDocumentsResponse_Found{Found: pdoc},
+					ReadTime: aTimestamp2,
+				},
+			})
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Collection("C").Doc("a").Get(ctx)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(err)
+	}
+	assert.Empty(srv.expectations)
+
+	// parallel writes
+	errs = make(chan error, n)
+	for i := 0; i < n; i++ {
+		srv.AddRPC(nil, &pb.CommitResponse{
+			WriteResults: []*pb.WriteResult{{UpdateTime: aTimestamp3}},
+		})
+	}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Collection("C").Doc("a").Set(ctx, map[string]interface{}{"f": 1})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(err)
+	}
+	assert.Empty(srv.expectations)
+}
+
+func TestConcurrentReset(t *testing.T) {
+	_, srv, err := New()
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			srv.AddRPC(nil, &pb.CommitResponse{})
+		}()
+		go func() {
+			defer wg.Done()
+			srv.Reset()
+		}()
+		go func() {
+			defer wg.Done()
+			srv.AddRPCAdjust(nil, &pb.CommitResponse{}, func(proto.Message) {})
+		}()
+	}
+	wg.Wait()
+	srv.Reset()
+	assert.Nil(t, srv.expectations)
+}
+
+func TestSetUnordered(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	reqA := &pb.BatchGetDocumentsRequest{
+		Database:  "projects/projectID/databases/(default)",
+		Documents: []string{"projects/projectID/databases/(default)/documents/C/a"},
+	}
+	reqB := &pb.BatchGetDocumentsRequest{
+		Database:  "projects/projectID/databases/(default)",
+		Documents: []string{"projects/projectID/databases/(default)/documents/C/b"},
+	}
+	reqC := &pb.BatchGetDocumentsRequest{
+		Database:  "projects/projectID/databases/(default)",
+		Documents: []string{"projects/projectID/databases/(default)/documents/C/c"},
+	}
+
+	// test ordered mismatch
+	srv.AddRPC(reqA, []interface{}{})
+	_, err = srv.popRPC(context.Background(), reqB)
+	assert.NotNil(err)
+	assert.Len(srv.expectations, 1)
+	assert.NotNil(srv.CheckExpectations())
+	srv.Reset()
+
+	// test out of order success
+	srv.SetUnordered(true)
+	respA := &pb.BatchGetDocumentsResponse{Transaction: []byte("a")}
+	respB := &pb.BatchGetDocumentsResponse{Transaction: []byte("b")}
+	srv.AddRPC(reqA, []interface{}{respA})
+	srv.AddRPC(reqB, []interface{}{respB})
+	resp, err := srv.popRPC(context.Background(), reqB)
+	assert.Nil(err)
+	assert.Equal([]interface{}{respB}, resp)
+	resp, err = srv.popRPC(context.Background(), reqA)
+	assert.Nil(err)
+	assert.Equal([]interface{}{respA}, resp)
+	assert.Empty(srv.expectations)
+
+	// test mismatch lists every pending expectation and consumes none
+	srv.AddRPC(reqA, []interface{}{})
+	srv.AddRPC(reqB, []interface{}{})
+	_, err = srv.popRPC(context.Background(), reqC)
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "Bad request for BatchGetDocuments, none of 2 pending expectations matched")
+		assert.Contains(err.Error(), "documents/C/a")
+		assert.Contains(err.Error(), "documents/C/b")
+	}
+	assert.Len(srv.expectations, 2)
+
+	// test adjust is skipped for requests of another type
+	srv.Reset()
+	srv.AddRPCAdjust(&pb.CommitRequest{}, &pb.CommitResponse{}, func(gotReq proto.Message) {
+		gotReq.(*pb.CommitRequest).Transaction = nil
+	})
+	srv.AddRPC(reqA, []interface{}{})
+	_, err = srv.popRPC(context.Background(), reqA)
+	assert.Nil(err)
+	_, err = srv.popRPC(context.Background(), &pb.CommitRequest{Transaction: []byte("t")})
+	assert.Nil(err)
+
+	// test adjust of a non-matching expectation does not leak into the next
+	srv.Reset()
+	srv.AddRPCAdjust(&pb.CommitRequest{Database: "x"}, &pb.CommitResponse{}, func(gotReq proto.Message) {
+		gotReq.(*pb.CommitRequest).Transaction = nil
+	})
+	srv.AddRPC(&pb.CommitRequest{Database: "b", Transaction: []byte("t")}, &pb.CommitResponse{})
+	_, err = srv.popRPC(context.Background(), &pb.CommitRequest{Database: "b", Transaction: []byte("t")})
+	assert.Nil(err)
+	assert.Len(srv.expectations, 1)
+}
+
+func TestUnorderedConcurrentGets(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	srv.SetUnordered(true)
+
+	ids := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	dbPath := "projects/projectID/databases/(default)"
+	for _, id := range ids {
+		path := dbPath + "/documents/C/" + id
+		srv.AddRPC(
+			&pb.BatchGetDocumentsRequest{
+				Database:  dbPath,
+				Documents: []string{path},
+			},
+			[]interface{}{
+				&pb.BatchGetDocumentsResponse{
+					Result: &pb.BatchGetDocumentsResponse_Found{Found: &pb.Document{
+						Name:       path,
+						CreateTime: aTimestamp,
+						UpdateTime: aTimestamp,
+					}},
+					ReadTime: aTimestamp2,
+				},
+			},
+		)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			_, err := client.Collection("C").Doc(id).Get(context.Background())
+			errs <- err
+		}(ids[i])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(err)
+	}
+	assert.Empty(srv.expectations)
+}
+
+func TestPerMethodQueues(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	// test expectations for other methods are skipped
+	listen := func(id int32) []interface{} {
+		return []interface{}{&pb.ListenResponse{ResponseType: &pb.ListenResponse_TargetChange{
+			TargetChange: &pb.TargetChange{TargetIds: []int32{id}},
+		}}}
+	}
+	srv.AddRPC(&pb.ListenRequest{Database: "d"}, listen(1))
+	srv.AddRPC(&pb.CommitRequest{Database: "d"}, &pb.CommitResponse{})
+	srv.AddRPC(&pb.ListenRequest{Database: "d2"}, listen(2))
+	resp, err := srv.popRPC(context.Background(), &pb.CommitRequest{Database: "d"})
+	assert.Nil(err)
+	assert.Equal(&pb.CommitResponse{}, resp)
+	resp, err = srv.popRPC(context.Background(), &pb.ListenRequest{Database: "d"})
+	assert.Nil(err)
+	assert.Equal(listen(1), resp)
+
+	// test ordering is still enforced within a method
+	srv.AddRPC(&pb.ListenRequest{Database: "d3"}, listen(3))
+	_, err = srv.popRPC(context.Background(), &pb.ListenRequest{Database: "d3"})
+	assert.NotNil(err)
+	resp, err = srv.popRPC(context.Background(), &pb.ListenRequest{Database: "d2"})
+	assert.Nil(err)
+	assert.Equal(listen(2), resp)
+	resp, err = srv.popRPC(context.Background(), &pb.ListenRequest{Database: "d3"})
+	assert.Nil(err)
+	assert.Equal(listen(3), resp)
+
+	// test nil wantReq is queued for every method
+	srv.AddRPC(&pb.RollbackRequest{}, &empty.Empty{})
+	anyResp := &pb.CommitResponse{CommitTime: aTimestamp}
+	srv.AddRPC(nil, anyResp)
+	resp, err = srv.popRPC(context.Background(), &pb.CommitRequest{})
+	assert.Nil(err)
+	assert.Equal(anyResp, resp)
+
+	// test out of RPCs for a method with expectations for other methods
+	_, err = srv.popRPC(context.Background(), &pb.CommitRequest{})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	assert.Len(srv.expectations, 1)
+}
+
+func TestSetReporter(t *testing.T) {
+	assert := assert.New(t)
+
+	client, srv, err := New()
+	assert.Nil(err)
+	ft := &fakeT{}
+	srv.SetReporter(ft)
+
+	// test an unexpected call fails the owning test and the client call
+	_, err = client.Collection("C").Doc("a").Set(context.Background(), map[string]int{"a": 1})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	assert.Contains(status.Convert(err).Message(), "mockfs.popRPC: Out of RPCs for Commit")
+	if assert.Len(ft.errors, 1) {
+		assert.Contains(ft.errors[0], "mockfs.popRPC: Out of RPCs for Commit")
+		assert.Contains(ft.errors[0], "documents/C/a")
+	}
+
+	// test a mismatched request is reported
+	srv.AddRPC(&pb.GetDocumentRequest{Name: "a"}, &pb.Document{})
+	_, err = srv.popRPC(context.Background(), &pb.GetDocumentRequest{Name: "b"})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	if assert.Len(ft.errors, 2) {
+		assert.Contains(ft.errors[1], "mockfs.popRPC: Bad request")
+	}
+	srv.Reset()
+
+	// test a bad response type is reported
+	srv.AddRPC(nil, &pb.Document{})
+	_, err = srv.Commit(context.Background(), &pb.CommitRequest{})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	if assert.Len(ft.errors, 3) {
+		assert.Equal("mockfs.Commit: Bad response type: *firestorepb.Document", ft.errors[2])
+	}
+
+	// test scripted errors are not reported
+	srv.AddRPC(nil, errors.NewNotFoundError(""))
+	_, err = srv.popRPC(context.Background(), &pb.GetDocumentRequest{})
+	assert.Equal(codes.NotFound, status.Code(err))
+	assert.Len(ft.errors, 3)
+
+	// test removing the reporter
+	srv.SetReporter(nil)
+	_, err = srv.popRPC(context.Background(), &pb.GetDocumentRequest{})
+	assert.NotNil(err)
+	assert.Len(ft.errors, 3)
+
+	// test the reporter is removed when its test completes
+	srv.SetReporter(ft)
+	if assert.Len(ft.cleanups, 2) {
+		ft.cleanups[1]()
+	}
+	_, err = srv.popRPC(context.Background(), &pb.GetDocumentRequest{})
+	assert.NotNil(err)
+	assert.Len(ft.errors, 3)
+
+	// test an older reporter's cleanup leaves a newer reporter in place
+	ft2 := &fakeT{}
+	srv.SetReporter(ft)
+	srv.SetReporter(ft2)
+	ft.cleanups[2]()
+	_, err = srv.popRPC(context.Background(), &pb.GetDocumentRequest{})
+	assert.NotNil(err)
+	assert.Len(ft2.errors, 1)
+
+	// test a call after a subtest ends does not fail the finished subtest
+	t.Run("sub", func(t *testing.T) {
+		srv.SetReporter(t)
+	})
+	_, err = srv.GetDocument(context.Background(), &pb.GetDocumentRequest{})
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+}
@@ -0,0 +1,113 @@
+package mockfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+var pkgPath = reflect.TypeOf(MockServer{}).PkgPath()
+
+// checkResponse checks that resp is a valid scripted response for method. It
+// returns the response to queue, with typed slices of stream messages
+// converted to the []interface{} form used by the streaming handlers, and a
+// message describing the problem, or "" if there is none.
+func checkResponse(method string, resp interface{}) (interface{}, string) {
+	t, ok := rpcTypes[method]
+	if !ok {
+		return resp, fmt.Sprintf("%s is not implemented by the mock", method)
+	}
+	if _, ok := resp.(error); ok {
+		return resp, ""
+	}
+	v := reflect.ValueOf(resp)
+	if v.Kind() == reflect.Func {
+		if !t.acceptsResponder(v.Type()) {
+			return resp, fmt.Sprintf("Bad responder type for %s: %T", method, resp)
+		}
+		return resp, ""
+	}
+	if !t.stream {
+		if reflect.TypeOf(resp) != t.resp {
+			return resp, fmt.Sprintf("Bad response type for %s: %T, want %v or error", method, resp, t.resp)
+		}
+		return resp, ""
+	}
+	if v.Kind() == reflect.Slice {
+		resp = streamValues(v)
+	}
+	values, ok := resp.([]interface{})
+	if !ok {
+		return resp, fmt.Sprintf("Bad response type for %s: %T, want []interface{} or error", method, resp)
+	}
+	for i, v := range values {
+		if _, ok := v.(error); !ok && reflect.TypeOf(v) != t.resp {
+			return resp, fmt.Sprintf("Bad response type for %s at index %d: %T, want %v or error", method, i, v, t.resp)
+		}
+	}
+	return resp, ""
+}
+
+// anyMethodResponse converts a typed slice of proto messages, such as
+// []*pb.RunQueryResponse, into the []interface{} form used by the streaming
+// handlers. Other responses are returned unchanged, as they are not checked
+// against a method.
+func anyMethodResponse(resp interface{}) interface{} {
+	v := reflect.ValueOf(resp)
+	if v.Kind() == reflect.Slice && v.Type().Elem().Implements(messageType) {
+		return streamValues(v)
+	}
+	return resp
+}
+
+// acceptsResponder reports whether a responder function of type ft can answer
+// requests of the method described by t.
+func (t rpcType) acceptsResponder(ft reflect.Type) bool {
+	in := ft.NumIn()
+	if in < 1 || in > 2 || in == 2 && ft.In(0) != contextType || !t.req.AssignableTo(ft.In(in-1)) {
+		return false
+	}
+	if ft.NumOut() != 2 || ft.Out(1) != errorType {
+		return false
+	}
+	out := ft.Out(0)
+	if t.stream {
+		return out.Kind() == reflect.Slice && t.resp.AssignableTo(out.Elem())
+	}
+	return t.resp.AssignableTo(out)
+}
+
+// misuse reports a mistake made by the test author, such as a scripted
+// response of the wrong type, with the location of the offending call. It
+// panics if the server has no reporter.
+func (s *MockServer) misuse(msg string) {
+	if loc := callerLocation(); loc != "" {
+		msg += " (" + loc + ")"
+	}
+	s.mu.Lock()
+	reporter := s.reporter
+	s.mu.Unlock()
+	if reporter == nil {
+		panic(msg)
+	}
+	reporter.Helper()
+	reporter.Errorf("%s", msg)
+}
+
+// callerLocation returns the file and line of the innermost call made from
+// outside the package's own, non-test, source files.
+func callerLocation() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, pkgPath+".") || strings.HasSuffix(f.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", filepath.Base(f.File), f.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
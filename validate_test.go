@@ -0,0 +1,110 @@
+package mockfs
+
+import (
+	"context"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	errors "github.com/weathersource/go-errors"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestCheckResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	valid := []struct {
+		method string
+		resp   interface{}
+	}{
+		{MethodCommit, &pb.CommitResponse{}},
+		{MethodCommit, errors.NewAbortedError("")},
+		{MethodRollback, &empty.Empty{}},
+		{MethodRunQuery, []interface{}{}},
+		{MethodRunQuery, []interface{}{&pb.RunQueryResponse{}, errors.NewUnavailableError("")}},
+		{MethodRunQuery, []*pb.RunQueryResponse{{}}},
+		{MethodListen, []interface{}{&pb.ListenResponse{}}},
+		{MethodCommit, func(*pb.CommitRequest) (*pb.CommitResponse, error) { return nil, nil }},
+		{MethodCommit, func(context.Context, *pb.CommitRequest) (*pb.CommitResponse, error) { return nil, nil }},
+		{MethodRunQuery, func(*pb.RunQueryRequest) ([]*pb.RunQueryResponse, error) { return nil, nil }},
+		{MethodRunQuery, func(*pb.RunQueryRequest) ([]interface{}, error) { return nil, nil }},
+	}
+	for _, test := range valid {
+		_, msg := checkResponse(test.method, test.resp)
+		assert.Empty(msg, "%s %T", test.method, test.resp)
+	}
+
+	resp, _ := checkResponse(MethodRunQuery, []*pb.RunQueryResponse{{}})
+	assert.IsType([]interface{}{}, resp)
+
+	invalid := []struct {
+		method string
+		resp   interface{}
+		want   string
+	}{
+		{MethodCommit, &pb.Document{}, "Bad response type for Commit: *firestorepb.Document, want *firestorepb.CommitResponse or error"},
+		{MethodRollback, nil, "Bad response type for Rollback: <nil>, want *emptypb.Empty or error"},
+		{MethodRunQuery, &pb.RunQueryResponse{}, "Bad response type for RunQuery: *firestorepb.RunQueryResponse, want []interface{} or error"},
+		{MethodRunQuery, []interface{}{&pb.RunQueryResponse{}, &pb.Document{}},
+			"Bad response type for RunQuery at index 1: *firestorepb.Document, want *firestorepb.RunQueryResponse or error"},
+		{MethodListen, []*pb.RunQueryResponse{{}},
+			"Bad response type for Listen at index 0: *firestorepb.RunQueryResponse, want *firestorepb.ListenResponse or error"},
+		{MethodCommit, func(*pb.GetDocumentRequest) (*pb.CommitResponse, error) { return nil, nil }, "Bad responder type for Commit"},
+		{MethodCommit, func(*pb.CommitRequest) (*pb.Document, error) { return nil, nil }, "Bad responder type for Commit"},
+		{MethodCommit, func(*pb.CommitRequest) *pb.CommitResponse { return nil }, "Bad responder type for Commit"},
+		{MethodRunQuery, func(*pb.RunQueryRequest) (*pb.RunQueryResponse, error) { return nil, nil }, "Bad responder type for RunQuery"},
+		{MethodCreateDocument, &pb.Document{}, "CreateDocument is not implemented by the mock"},
+	}
+	for _, test := range invalid {
+		_, msg := checkResponse(test.method, test.resp)
+		assert.Contains(msg, test.want)
+	}
+}
+
+func TestAddRPCValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	// test a bad response panics at the offending line without a reporter
+	assert.PanicsWithValue(
+		"mockfs.AddRPC: Bad response type for Commit: *firestorepb.Document, want *firestorepb.CommitResponse or error (validate_test.go:73)",
+		func() { srv.AddRPC(&pb.CommitRequest{}, &pb.Document{}) },
+	)
+	assert.Empty(srv.expectations)
+
+	// test it is reported with a reporter, and the expectation is not queued
+	ft := &fakeT{}
+	srv.SetReporter(ft)
+	e := srv.AddRPCMatcher(MethodRunQuery, Any(), &pb.RunQueryResponse{}).Times(2)
+	assert.NotNil(e)
+	if assert.Len(ft.errors, 1) {
+		assert.Contains(ft.errors[0], "Bad response type for RunQuery")
+		assert.Contains(ft.errors[0], "(validate_test.go:80)")
+	}
+	assert.Empty(srv.expectations)
+
+	// test typed stream slices are accepted
+	srv.AddRPC(&pb.RunQueryRequest{}, []*pb.RunQueryResponse{{ReadTime: aTimestamp}})
+	err = srv.RunQuery(&pb.RunQueryRequest{}, &RunQueryServer{})
+	assert.Nil(err)
+	assert.Len(ft.errors, 1)
+
+	// test typed stream slices are converted for any-method expectations
+	srv.AddRPC(nil, []*pb.RunQueryResponse{{ReadTime: aTimestamp}})
+	qs := &RunQueryServer{}
+	err = srv.RunQuery(&pb.RunQueryRequest{}, qs)
+	assert.Nil(err)
+	assert.NotNil(qs.resp)
+	assert.Len(ft.errors, 1)
+
+	// test other any-method responses are only checked when used
+	srv.AddRPC(nil, "not a response")
+	assert.Len(ft.errors, 1)
+	err = srv.RunQuery(&pb.RunQueryRequest{}, &RunQueryServer{})
+	assert.NotNil(err)
+	if assert.Len(ft.errors, 2) {
+		assert.Contains(ft.errors[1], "Bad response type: string")
+	}
+}
@@ -0,0 +1,37 @@
+package mockfs
+
+// TestingT is the subset of testing.TB used by MockServer to report test
+// failures. *testing.T and *testing.B satisfy it.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+	Helper()
+}
+
+// CleanupT is a TestingT that can register cleanup functions, such as
+// *testing.T.
+type CleanupT interface {
+	TestingT
+	Cleanup(func())
+}
+
+// Verify fails t with a listing of every expectation that was not consumed
+// as often as its call count constraint requires. It reports whether all
+// expectations were met.
+func (s *MockServer) Verify(t TestingT) bool {
+	t.Helper()
+	if msg := s.unmetMessage(); msg != "" {
+		t.Errorf("mockfs: %s", msg)
+		return false
+	}
+	return true
+}
+
+// VerifyOnCleanup arranges for Verify to be called when t and all its
+// subtests complete.
+func (s *MockServer) VerifyOnCleanup(t CleanupT) {
+	t.Helper()
+	t.Cleanup(func() {
+		t.Helper()
+		s.Verify(t)
+	})
+}
@@ -0,0 +1,97 @@
+package mockfs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	errors "github.com/weathersource/go-errors"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+)
+
+type fakeT struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Cleanup(f func()) {
+	t.cleanups = append(t.cleanups, f)
+}
+
+func TestVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	ft := &fakeT{}
+	assert.True(srv.Verify(ft))
+	assert.Empty(ft.errors)
+
+	srv.AddRPC(&pb.CommitRequest{Database: "d"}, &pb.CommitResponse{})
+	srv.AddRPC(&pb.GetDocumentRequest{Name: "a"}, &pb.Document{})
+	srv.AddRPC(nil, &pb.Document{}).AnyTimes()
+	_, err = srv.popRPC(context.Background(), &pb.GetDocumentRequest{Name: "a"})
+	assert.Nil(err)
+
+	assert.False(srv.Verify(ft))
+	if assert.Len(ft.errors, 1) {
+		msg := ft.errors[0]
+		assert.Contains(msg, "mockfs: 1 expectations not met:")
+		assert.Contains(msg, "[0] Commit expectation (want exactly 1, called 0 times)")
+		assert.Contains(msg, "want: *firestorepb.CommitRequest")
+		assert.NotContains(msg, "GetDocument")
+		assert.NotContains(msg, "any method")
+	}
+
+	// test the error form
+	err = srv.CheckExpectations()
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "mockfs.CheckExpectations: 1 expectations not met:")
+	}
+
+	// test a mismatched request leaves the expectation unmet, even when the
+	// error is swallowed by the code under test
+	srv.Reset()
+	srv.AddRPC(&pb.CommitRequest{Database: "a"}, &pb.CommitResponse{})
+	_, err = srv.popRPC(context.Background(), &pb.CommitRequest{Database: "b"})
+	assert.NotNil(err)
+	ft = &fakeT{}
+	assert.False(srv.Verify(ft))
+	if assert.Len(ft.errors, 1) {
+		assert.Contains(ft.errors[0], "Commit expectation (want exactly 1, called 0 times)")
+	}
+}
+
+func TestVerifyOnCleanup(t *testing.T) {
+	assert := assert.New(t)
+
+	_, srv, err := New()
+	assert.Nil(err)
+
+	ft := &fakeT{}
+	srv.VerifyOnCleanup(ft)
+	srv.AddRPC(&pb.RollbackRequest{}, errors.NewAbortedError(""))
+	if assert.Len(ft.cleanups, 1) {
+		ft.cleanups[0]()
+	}
+	if assert.Len(ft.errors, 1) {
+		assert.Contains(ft.errors[0], "Rollback expectation")
+	}
+
+	// test with a real *testing.T
+	t.Run("consumed", func(t *testing.T) {
+		srv.Reset()
+		srv.VerifyOnCleanup(t)
+		srv.AddRPC(nil, &pb.Document{})
+		_, err := srv.popRPC(context.Background(), &pb.GetDocumentRequest{})
+		assert.Nil(err)
+	})
+}